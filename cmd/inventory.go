@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/kreulenk/ez-monitor/pkg/inventory"
+	"github.com/spf13/cobra"
+)
+
+func genInventoryCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "inventory",
+		Short: "Work with inventory files",
+	}
+	cmd.AddCommand(genInventoryValidateCmd())
+	cmd.AddCommand(genInventoryEncryptCmd())
+	cmd.AddCommand(genInventoryDecryptCmd())
+	cmd.AddCommand(genInventoryEditCmd())
+	return cmd
+}
+
+func genInventoryValidateCmd() *cobra.Command {
+	var inventoryFormat string
+	var sshConfigFile string
+	var vaultPassword inventory.VaultPasswordOptions
+
+	var cmd = &cobra.Command{
+		Use:   "validate inventory-file",
+		Short: "Check an inventory for problems without connecting to any hosts",
+		Long: `Loads an inventory and reports every problem found in it along with the file and line it was found on.
+Exits with a non-zero status if any problems are found so that it can be used in CI`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			diagnostics := inventory.ValidateInventory(args[0], inventory.LoadOptions{Format: inventoryFormat, SshConfigFile: sshConfigFile, VaultPassword: vaultPassword})
+			if len(diagnostics) == 0 {
+				fmt.Printf("%s is valid\n", args[0])
+				return
+			}
+			for _, diagnostic := range diagnostics {
+				fmt.Println(diagnostic)
+			}
+			cobra.CheckErr(fmt.Errorf("found %d problem(s) in %s", len(diagnostics), args[0]))
+		},
+	}
+	cmd.Flags().StringVar(&sshConfigFile, "ssh-config", "", "OpenSSH client config used for hosts with ssh_config_host set")
+	cmd.Flags().StringVar(&inventoryFormat, "inventory-format", "", "Format of the inventory file (ini, yaml, toml, ansible or script). Detected from the file extension if not set")
+	addVaultPasswordFlags(cmd, &vaultPassword)
+
+	return cmd
+}
+
+func genInventoryEncryptCmd() *cobra.Command {
+	var vaultPassword inventory.VaultPasswordOptions
+
+	var cmd = &cobra.Command{
+		Use:   "encrypt inventory-file",
+		Short: "Encrypt an entire inventory file",
+		Long: `Encrypts the whole inventory file in place so that none of its hosts, usernames or addresses can be read
+without the encryption password. Encrypted inventories are decrypted in memory whenever they are loaded`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cobra.CheckErr(inventory.EncryptInventory(args[0], vaultPassword))
+			fmt.Printf("%s: encrypted\n", args[0])
+		},
+	}
+	addVaultPasswordFlags(cmd, &vaultPassword)
+	return cmd
+}
+
+func genInventoryDecryptCmd() *cobra.Command {
+	var vaultPassword inventory.VaultPasswordOptions
+
+	var cmd = &cobra.Command{
+		Use:   "decrypt inventory-file",
+		Short: "Decrypt an encrypted inventory file",
+		Long:  `Replaces an encrypted inventory file with its plain text contents`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cobra.CheckErr(inventory.DecryptInventory(args[0], vaultPassword))
+			fmt.Printf("%s: decrypted\n", args[0])
+		},
+	}
+	addVaultPasswordFlags(cmd, &vaultPassword)
+	return cmd
+}
+
+func genInventoryEditCmd() *cobra.Command {
+	var vaultPassword inventory.VaultPasswordOptions
+
+	var cmd = &cobra.Command{
+		Use:   "edit inventory-file",
+		Short: "Edit an encrypted inventory file",
+		Long: `Opens a decrypted copy of an encrypted inventory file in $VISUAL or $EDITOR, then encrypts the changes back
+into the file once the editor exits. The decrypted copy is only readable by the current user and is removed afterwards`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cobra.CheckErr(inventory.EditEncryptedInventory(args[0], vaultPassword))
+		},
+	}
+	addVaultPasswordFlags(cmd, &vaultPassword)
+	return cmd
+}
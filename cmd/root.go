@@ -10,18 +10,32 @@ import (
 	"github.com/spf13/cobra"
 	"os"
 	"runtime"
+	"slices"
+	"time"
 )
 
+// inventoryWatchInterval is how often the inventory files are checked for changes
+const inventoryWatchInterval = time.Second * 2
+
 func genRootCmd() *cobra.Command {
 	var version bool
 	var hostToAddEncryptedPassword string
+	var hostToAddEncryptedPassphrase string
+	var hostToAddEncryptedBecomePassword string
+	var inventoryFormat string
+	var sshConfigFile string
+	var inventoryRefresh time.Duration
+	var limit string
+	var tags string
+	var hostKeyPolicy string
+	var vaultPassword inventory.VaultPasswordOptions
 
 	var cmd = &cobra.Command{
-		Use:   "ez-monitor <inventory-file>",
+		Use:   "ez-monitor [inventory-file]",
 		Short: "An agentless SSH based system monitoring tool",
 		Long:  `EZ-Monitor allows you to easily monitor your Linux infrastructure by only requiring SSH connections`,
 		Args: func(cmd *cobra.Command, args []string) error {
-			if len(args) < 1 && !version {
+			if len(args) < 1 && !version && sshConfigFile == "" {
 				return errors.New("no inventory file was provided")
 			}
 			if len(args) > 1 {
@@ -36,27 +50,115 @@ func genRootCmd() *cobra.Command {
 				return
 			}
 			if hostToAddEncryptedPassword != "" {
-				err := inventory.BeginPasswordEncryptFlow(hostToAddEncryptedPassword, args[0])
+				if len(args) < 1 {
+					cobra.CheckErr(errors.New("an inventory file is required to add an encrypted password"))
+				}
+				err := inventory.BeginPasswordEncryptFlow(hostToAddEncryptedPassword, args[0], inventoryFormat)
+				cobra.CheckErr(err)
+				os.Exit(0)
+			}
+			if hostToAddEncryptedPassphrase != "" {
+				if len(args) < 1 {
+					cobra.CheckErr(errors.New("an inventory file is required to add an encrypted private key passphrase"))
+				}
+				err := inventory.BeginKeyPassphraseEncryptFlow(hostToAddEncryptedPassphrase, args[0], inventoryFormat)
+				cobra.CheckErr(err)
+				os.Exit(0)
+			}
+			if hostToAddEncryptedBecomePassword != "" {
+				if len(args) < 1 {
+					cobra.CheckErr(errors.New("an inventory file is required to add an encrypted sudo password"))
+				}
+				err := inventory.BeginBecomePasswordEncryptFlow(hostToAddEncryptedBecomePassword, args[0], inventoryFormat)
 				cobra.CheckErr(err)
 				os.Exit(0)
 			}
 
-			inventoryInfo, err := inventory.LoadInventory(args[0])
+			selector, err := inventory.NewSelector(limit, tags)
 			cobra.CheckErr(err)
-
-			statsChan, err := statistics.StartStatisticsCollection(ctx, inventoryInfo)
+			policy, err := statistics.ParseHostKeyPolicy(hostKeyPolicy)
 			cobra.CheckErr(err)
-			tui.Initialize(ctx, inventoryInfo, statsChan)
+			loadOpts := inventory.LoadOptions{Format: inventoryFormat, SshConfigFile: sshConfigFile, VaultPassword: vaultPassword}
+			loadInventory := func(opts inventory.LoadOptions) ([]inventory.Host, []string, error) {
+				var hosts []inventory.Host
+				var paths []string
+				var err error
+				if len(args) == 1 {
+					hosts, paths, err = inventory.LoadInventoryFiles(args[0], opts)
+				} else { // Without an inventory file every host in the ssh config is monitored
+					hosts, err = inventory.LoadSshConfig(sshConfigFile)
+				}
+				return selector.Select(hosts), paths, err
+			}
+			inventoryInfo, inventoryPaths, err := loadInventory(loadOpts)
+			cobra.CheckErr(err)
+			if len(inventoryInfo) == 0 && (limit != "" || tags != "") {
+				cobra.CheckErr(errors.New("no hosts in the inventory match the given --limit and --tags"))
+			}
+
+			collector := statistics.StartStatisticsCollection(ctx, inventoryInfo, policy)
+			if len(args) == 1 || inventoryRefresh > 0 { // Pick up edits to the inventory file, and refresh it if asked to
+				reloadOpts := loadOpts
+				reloadOpts.VaultPassword.NoPrompt = true // See VaultPasswordOptions.NoPrompt
+				reloads := inventory.Watch(ctx, inventoryPaths, inventoryWatchInterval, inventoryRefresh, func() ([]inventory.Host, []string, error) {
+					return loadInventory(reloadOpts)
+				})
+				go collector.WatchInventory(reloads)
+			}
+			tui.Initialize(ctx, inventoryInfo, collector.Stats(), collector.Hosts(), collector.ReloadErrors())
 		},
 	}
 	cmd.Flags().BoolVarP(&version, "version", "v", false, "Show version information")
 	cmd.Flags().StringVar(&hostToAddEncryptedPassword, "add-encrypted-pass", "", "Specify the alias of a host in the host file for which you'd like to set an encrypted password")
+	cmd.Flags().StringVar(&hostToAddEncryptedPassphrase, "add-encrypted-key-passphrase", "", "Specify the alias of a host in the host file for which you'd like to set an encrypted private key passphrase")
+	cmd.Flags().StringVar(&hostToAddEncryptedBecomePassword, "add-encrypted-become-pass", "", "Specify the alias of a host in the host file for which you'd like to set an encrypted sudo password")
+	cmd.Flags().StringVar(&sshConfigFile, "ssh-config", "", "OpenSSH client config used for hosts with ssh_config_host set. If no inventory file is given, every host in it is monitored")
+	cmd.Flags().StringVar(&inventoryFormat, "inventory-format", "", "Format of the inventory file (ini, yaml, toml, ansible or script). Detected from the file extension if not set")
+	cmd.Flags().StringVar(&hostKeyPolicy, "host-key-policy", string(statistics.HostKeyPolicyStrict), "How to handle hosts whose key is not in known_hosts: strict refuses to connect, accept-new adds the key and prompt asks first")
+	cmd.Flags().DurationVar(&inventoryRefresh, "inventory-refresh", 0, "How often to reload the inventory and start monitoring any hosts added to it, e.g. 5m. Disabled if not set")
+
+	cmd.Flags().StringVar(&limit, "limit", "", "Comma separated host alias or group patterns to monitor, e.g. web-*,db-1. Patterns starting with ! exclude hosts")
+	cmd.Flags().StringVar(&tags, "tags", "", "Only monitor hosts whose tags match the expression, e.g. 'prod and (web or db) and not canary'")
+	addVaultPasswordFlags(cmd, &vaultPassword)
+
+	cmd.AddCommand(genInventoryCmd())
+	cmd.AddCommand(genSecretsCmd())
 
 	return cmd
 }
 
+// addVaultPasswordFlags adds the flags for providing the encryption password of encrypted host passwords without
+// being prompted for it
+func addVaultPasswordFlags(cmd *cobra.Command, opts *inventory.VaultPasswordOptions) {
+	cmd.Flags().StringVar(&opts.File, "vault-password-file", "", "File containing the encryption password for encrypted host passwords")
+	cmd.Flags().StringVar(&opts.Command, "vault-password-command", "", "Command that prints the encryption password for encrypted host passwords, e.g. 'pass show ez-monitor'")
+	cmd.MarkFlagsMutuallyExclusive("vault-password-file", "vault-password-command")
+}
+
+// inventoryFileArgs lets an inventory file share its name with a subcommand, such as Ansible's conventional inventory
+// file. If the arguments select a subcommand that only groups other subcommands and a file or directory with its name
+// exists, the name is taken as a path to the inventory instead
+func inventoryFileArgs(rootCmd *cobra.Command, args []string) []string {
+	subCmd, _, err := rootCmd.Find(args)
+	if err != nil || subCmd.Parent() != rootCmd || subCmd.Runnable() {
+		return args
+	}
+	if _, err := os.Stat(subCmd.Name()); err != nil {
+		return args
+	}
+	args = slices.Clone(args)
+	for i, arg := range args {
+		if arg == subCmd.Name() {
+			args[i] = "." + string(os.PathSeparator) + arg
+			break
+		}
+	}
+	return args
+}
+
 func Execute() {
 	rootCmd := genRootCmd()
+	rootCmd.SetArgs(inventoryFileArgs(rootCmd, os.Args[1:]))
 	err := rootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/kreulenk/ez-monitor/pkg/inventory"
+	"github.com/spf13/cobra"
+)
+
+func genSecretsCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage the encrypted passwords in inventory files",
+	}
+	cmd.AddCommand(genSecretsMigrateCmd())
+	cmd.AddCommand(genSecretsRekeyCmd())
+	return cmd
+}
+
+func genSecretsMigrateCmd() *cobra.Command {
+	var vaultPassword inventory.VaultPasswordOptions
+
+	var cmd = &cobra.Command{
+		Use:   "migrate inventory-file...",
+		Short: "Upgrade encrypted passwords to the current encryption format",
+		Long: `Re-encrypts every password that was encrypted by an earlier version of ez-monitor with the current, stronger
+encryption format. Files are edited in place, keeping their formatting and comments`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, filename := range args {
+				migrated, err := inventory.MigrateEncryptedPasswords(filename, vaultPassword)
+				cobra.CheckErr(err)
+				fmt.Printf("%s: migrated %d password(s)\n", filename, migrated)
+			}
+		},
+	}
+	addVaultPasswordFlags(cmd, &vaultPassword)
+	return cmd
+}
+
+func genSecretsRekeyCmd() *cobra.Command {
+	var vaultPassword inventory.VaultPasswordOptions
+
+	var cmd = &cobra.Command{
+		Use:   "rekey inventory-file",
+		Short: "Change the encryption password of an inventory file",
+		Long: `Prompts for a new encryption password, then re-encrypts every password in the file with the new
+encryption password. The file is edited in place, keeping its formatting and comments, and is left unchanged if any
+password cannot be decrypted with the current encryption password. An encrypted inventory is re-encrypted as a whole.
+Files with Ansible Vault values are refused, as those values cannot be re-encrypted`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			rekeyed, err := inventory.RekeyEncryptedPasswords(args[0], vaultPassword)
+			cobra.CheckErr(err)
+			fmt.Printf("%s: re-encrypted %d password(s)\n", args[0], rekeyed)
+		},
+	}
+	addVaultPasswordFlags(cmd, &vaultPassword)
+	return cmd
+}
@@ -3,6 +3,7 @@ module github.com/kreulenk/ez-monitor
 go 1.24
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/charmbracelet/bubbles v0.21.0
 	github.com/charmbracelet/bubbletea v1.3.6
 	github.com/charmbracelet/lipgloss v1.1.0
@@ -11,6 +12,7 @@ require (
 	github.com/spf13/cobra v1.9.1
 	golang.org/x/crypto v0.40.0
 	golang.org/x/term v0.33.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -34,8 +36,4 @@ require (
 	golang.org/x/text v0.27.0 // indirect
 )
 
-require (
-	github.com/stretchr/testify v1.10.0 // indirect
-	golang.org/x/sys v0.34.0 // indirect
-	gopkg.in/ini.v1 v1.67.0
-)
+require golang.org/x/sys v0.34.0 // indirect
@@ -0,0 +1,410 @@
+package inventory
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	ansibleUngroupedGroupName = "ungrouped"
+	// ansibleVarPrefix allows ez-monitor specific variables to be set in an Ansible inventory, e.g. ez_monitor_port
+	ansibleVarPrefix = "ez_monitor_"
+)
+
+// ansibleVarNames maps the Ansible connection variables onto their ez-monitor equivalent. Any other Ansible variable
+// is ignored as it most likely exists for the playbooks that share the inventory
+var ansibleVarNames = map[string]string{
+	"ansible_host":                 "address",
+	"ansible_ssh_host":             "address",
+	"ansible_user":                 "username",
+	"ansible_ssh_user":             "username",
+	"ansible_port":                 "port",
+	"ansible_ssh_port":             "port",
+	"ansible_password":             "password",
+	"ansible_ssh_pass":             "password",
+	"ansible_ssh_password":         "password",
+	"ansible_ssh_private_key_file": "ssh_private_key_file",
+	"ansible_private_key_file":     "ssh_private_key_file",
+	"ansible_become":               "become",
+	"ansible_become_password":      "become_password",
+	"ansible_become_pass":          "become_password",
+}
+
+// translateAnsibleVars renames Ansible connection variables to the names used by ez-monitor and drops everything else
+func translateAnsibleVars(vars []variable) []variable {
+	var translated []variable
+	for _, v := range vars {
+		if key, ok := ansibleVarNames[v.key]; ok {
+			v.key = key
+			translated = append(translated, v)
+		} else if key, ok := strings.CutPrefix(v.key, ansibleVarPrefix); ok {
+			v.key = key
+			translated = append(translated, v)
+		}
+	}
+	return translated
+}
+
+// ansibleInventory collects the hosts and groups of an Ansible inventory. Unlike the ez-monitor formats, a host may
+// be declared any number of times with each declaration adding to its variables
+type ansibleInventory struct {
+	inv          inventoryDefinition
+	hostIndexes  map[string]int
+	groupIndexes map[string]int
+}
+
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{
+		hostIndexes:  make(map[string]int),
+		groupIndexes: make(map[string]int),
+	}
+}
+
+func (a *ansibleInventory) addHost(alias string, vars []variable, line int) {
+	i, ok := a.hostIndexes[alias]
+	if !ok {
+		i = len(a.inv.hosts)
+		a.hostIndexes[alias] = i
+		// Ansible uses the inventory hostname as the address if ansible_host is not set
+		a.inv.hosts = append(a.inv.hosts, hostDefinition{alias: alias, implicit: true, line: line})
+	}
+	a.inv.hosts[i].vars = append(a.inv.hosts[i].vars, translateAnsibleVars(vars)...)
+}
+
+// group returns the named group, or nil for all and ungrouped as every host is implicitly a member of those
+func (a *ansibleInventory) group(name string) *groupDefinition {
+	if name == allGroupName || name == ansibleUngroupedGroupName {
+		return nil
+	}
+	i, ok := a.groupIndexes[name]
+	if !ok {
+		i = len(a.inv.groups)
+		a.groupIndexes[name] = i
+		a.inv.groups = append(a.inv.groups, groupDefinition{name: name})
+	}
+	return &a.inv.groups[i]
+}
+
+func (a *ansibleInventory) addGroupMember(groupName, alias string) {
+	if group := a.group(groupName); group != nil {
+		group.members = append(group.members, alias)
+	}
+}
+
+func (a *ansibleInventory) addGroupVars(groupName string, vars []variable) {
+	vars = translateAnsibleVars(vars)
+	if groupName == allGroupName {
+		a.inv.vars = append(a.inv.vars, vars...)
+	} else if group := a.group(groupName); group != nil {
+		group.vars = append(group.vars, vars...)
+	}
+}
+
+func (a *ansibleInventory) addGroupChild(groupName, child string) {
+	a.group(child) // The child may not have been declared yet, or at all if it has no hosts
+	if group := a.group(groupName); group != nil {
+		group.children = append(group.children, child)
+	}
+}
+
+// isAnsibleINI reports whether an ini file is an Ansible inventory rather than an ez-monitor one. Ansible inventories
+// declare hosts on a single line with inline variables, may list hosts before the first section, and use ansible_
+// connection variables
+func isAnsibleINI(sections []iniSection) bool {
+	for _, section := range sections {
+		for _, entry := range section.entries {
+			if section.name == defaultSectionName && entry.bare || strings.HasPrefix(entry.key, "ansible_") {
+				return true
+			}
+			if entry.bare && stripINIComment(entry.raw) != entry.key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseAnsibleINI reads an Ansible ini inventory. Hosts are declared as a line within their group, optionally followed
+// by key=value variables, and may be repeated across groups
+func parseAnsibleINI(sections []iniSection) (inventoryDefinition, error) {
+	a := newAnsibleInventory()
+	for _, section := range sections {
+		switch {
+		case strings.HasSuffix(section.name, groupVarsSuffix):
+			groupName := strings.TrimSuffix(section.name, groupVarsSuffix)
+			vars, err := iniSectionVars(section)
+			if err != nil {
+				return inventoryDefinition{}, err
+			}
+			a.addGroupVars(groupName, vars)
+		case strings.HasSuffix(section.name, childrenSuffix):
+			groupName := strings.TrimSuffix(section.name, childrenSuffix)
+			for _, entry := range section.entries {
+				a.addGroupChild(groupName, entry.key)
+			}
+		default:
+			groupName := section.name
+			if groupName == defaultSectionName {
+				groupName = ansibleUngroupedGroupName
+			}
+			a.group(groupName) // Groups are recorded even when they are empty so they can be used as children
+			for _, entry := range section.entries {
+				alias, vars, err := parseAnsibleHostLine(entry.raw)
+				if err != nil {
+					return inventoryDefinition{}, fmt.Errorf("line %d: %s", entry.line, err)
+				}
+				for i := range vars {
+					vars[i].line = entry.line
+				}
+				a.addHost(alias, vars, entry.line)
+				a.addGroupMember(groupName, alias)
+			}
+		}
+	}
+	return a.inv, nil
+}
+
+// parseAnsibleHostLine splits a host declaration such as
+//
+//	web-1.example.com:2222 ansible_user=deploy ansible_ssh_private_key_file="~/.ssh/deploy key"
+//
+// into the host's name and its variables. A port after the name is treated the same as ansible_port
+func parseAnsibleHostLine(line string) (string, []variable, error) {
+	fields, err := splitQuotedFields(line)
+	if err != nil {
+		return "", nil, err
+	}
+
+	alias := fields[0]
+	var vars []variable
+	if colon := strings.LastIndex(alias, ":"); colon != -1 && strings.Count(alias, ":") == 1 {
+		if _, err := strconv.Atoi(alias[colon+1:]); err == nil {
+			vars = append(vars, variable{key: "ansible_port", value: alias[colon+1:]})
+			alias = alias[:colon]
+		}
+	}
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "#") || strings.HasPrefix(field, ";") { // Inline comment
+			break
+		}
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return "", nil, fmt.Errorf("expected key=value for host %s but found %s", alias, field)
+		}
+		vars = append(vars, variable{key: key, value: value})
+	}
+	return alias, vars, nil
+}
+
+// splitQuotedFields splits a line on whitespace while keeping single or double quoted text together, with the quotes removed
+func splitQuotedFields(line string) ([]string, error) {
+	var fields []string
+	var current strings.Builder
+	var quote rune
+	inField := false
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+		default:
+			current.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %s", line)
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields, nil
+}
+
+// isAnsibleYAML reports whether a yaml inventory is an Ansible one, which has group names such as all at its top
+// level instead of the hosts, groups and vars keys of an ez-monitor inventory
+func isAnsibleYAML(root *yaml.Node) bool {
+	for i := 0; i < len(root.Content); i += 2 {
+		switch root.Content[i].Value {
+		case "hosts", "groups", "vars", "include":
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// parseAnsibleYAML reads an Ansible yaml inventory where every group, starting with all, may have hosts, vars and
+// children groups of its own. The JSON printed by Ansible dynamic inventory scripts is read here as well, where hosts
+// and children are lists of names and host variables are found under _meta.hostvars
+func parseAnsibleYAML(root *yaml.Node) (inventoryDefinition, error) {
+	a := newAnsibleInventory()
+	for i := 0; i < len(root.Content); i += 2 {
+		var err error
+		if root.Content[i].Value == "_meta" {
+			err = parseAnsibleMeta(a, root.Content[i+1])
+		} else {
+			err = parseAnsibleYAMLGroup(a, root.Content[i].Value, root.Content[i+1])
+		}
+		if err != nil {
+			return inventoryDefinition{}, err
+		}
+	}
+	return a.inv, nil
+}
+
+// parseAnsibleMeta reads the host variables of a dynamic inventory, which are given separately from the groups
+func parseAnsibleMeta(a *ansibleInventory, node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: _meta must be a mapping", node.Line)
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value != "hostvars" || node.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		hostvars := node.Content[i+1]
+		for j := 0; j < len(hostvars.Content); j += 2 {
+			alias := hostvars.Content[j].Value
+			vars, err := parseAnsibleYAMLVars(hostvars.Content[j+1])
+			if err != nil {
+				return fmt.Errorf("host %s: %s", alias, err)
+			}
+			a.addHost(alias, vars, hostvars.Content[j].Line)
+		}
+	}
+	return nil
+}
+
+func parseAnsibleYAMLGroup(a *ansibleInventory, name string, node *yaml.Node) error {
+	a.group(name)
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil
+	}
+	if node.Kind == yaml.SequenceNode { // A group may be given as just a list of its hosts
+		node = &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{{Kind: yaml.ScalarNode, Value: "hosts"}, node}}
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: group %s must be a mapping", node.Line, name)
+	}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		switch keyNode.Value {
+		case "hosts":
+			if valueNode.Kind == yaml.ScalarNode && valueNode.Tag == "!!null" {
+				continue
+			}
+			if valueNode.Kind == yaml.SequenceNode {
+				for _, hostNode := range valueNode.Content {
+					a.addHost(hostNode.Value, nil, hostNode.Line)
+					a.addGroupMember(name, hostNode.Value)
+				}
+				continue
+			}
+			if valueNode.Kind != yaml.MappingNode {
+				return fmt.Errorf("line %d: hosts of group %s must be a mapping", valueNode.Line, name)
+			}
+			for j := 0; j < len(valueNode.Content); j += 2 {
+				alias := valueNode.Content[j].Value
+				vars, err := parseAnsibleYAMLVars(valueNode.Content[j+1])
+				if err != nil {
+					return fmt.Errorf("host %s: %s", alias, err)
+				}
+				a.addHost(alias, vars, valueNode.Content[j].Line)
+				a.addGroupMember(name, alias)
+			}
+		case "vars":
+			vars, err := parseAnsibleYAMLVars(valueNode)
+			if err != nil {
+				return fmt.Errorf("group %s: %s", name, err)
+			}
+			a.addGroupVars(name, vars)
+		case "children":
+			if valueNode.Kind == yaml.ScalarNode && valueNode.Tag == "!!null" {
+				continue
+			}
+			if valueNode.Kind == yaml.SequenceNode {
+				for _, childNode := range valueNode.Content {
+					a.addGroupChild(name, childNode.Value)
+				}
+				continue
+			}
+			if valueNode.Kind != yaml.MappingNode {
+				return fmt.Errorf("line %d: children of group %s must be a mapping", valueNode.Line, name)
+			}
+			for j := 0; j < len(valueNode.Content); j += 2 {
+				child := valueNode.Content[j].Value
+				if err := parseAnsibleYAMLGroup(a, child, valueNode.Content[j+1]); err != nil {
+					return err
+				}
+				a.addGroupChild(name, child)
+			}
+		default:
+			return fmt.Errorf("line %d: unknown key %s in group %s", keyNode.Line, keyNode.Value, name)
+		}
+	}
+	return nil
+}
+
+// parseAnsibleYAMLVars reads only the variables ez-monitor uses, as the values of other Ansible variables may be
+// structured data that has no meaning here
+func parseAnsibleYAMLVars(node *yaml.Node) ([]variable, error) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: variables must be a mapping", node.Line)
+	}
+
+	var vars []variable
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		if _, ok := ansibleVarNames[key]; !ok && !strings.HasPrefix(key, ansibleVarPrefix) {
+			continue
+		}
+		value, err := yamlScalarValue(node.Content[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %s", key, err)
+		}
+		vars = append(vars, variable{key: key, value: value, line: node.Content[i].Line})
+	}
+	return vars, nil
+}
+
+// parseAnsible reads a file that was explicitly marked as an Ansible inventory, choosing between the yaml and ini
+// layouts based off of the file extension
+func parseAnsible(filename string, data []byte) (inventoryDefinition, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		var document yaml.Node
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return inventoryDefinition{}, fmt.Errorf("failed to load yaml data: %s", err)
+		}
+		if len(document.Content) == 0 {
+			return inventoryDefinition{}, nil
+		}
+		if document.Content[0].Kind != yaml.MappingNode {
+			return inventoryDefinition{}, fmt.Errorf("line %d: the top level of a yaml inventory must be a mapping", document.Content[0].Line)
+		}
+		return parseAnsibleYAML(document.Content[0])
+	default:
+		sections, err := readINISections(data)
+		if err != nil {
+			return inventoryDefinition{}, fmt.Errorf("failed to load ini data: %s", err)
+		}
+		return parseAnsibleINI(sections)
+	}
+}
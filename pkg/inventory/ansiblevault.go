@@ -0,0 +1,96 @@
+package inventory
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Values encrypted with ansible-vault encrypt_string, such as
+//
+//	password: !vault |
+//	  $ANSIBLE_VAULT;1.1;AES256
+//	  62313365396662343061393464336163383764373764613633653634306231386433626436623361
+//	  ...
+//
+// are decrypted with the same encryption password as $EZ_MONITOR_ENCRYPTED; values. The lines after the header are
+// the hex encoding of the hex encoded salt, HMAC and ciphertext, each on their own line. Keys are derived with PBKDF2
+// and the value is encrypted with AES-256 in CTR mode
+const (
+	ansibleVaultHeader     = "$ANSIBLE_VAULT;"
+	ansibleVaultIterations = 10000
+	ansibleVaultKeySize    = 32
+)
+
+// isAnsibleVaultValue reports whether a variable's value is encrypted with Ansible Vault
+func isAnsibleVaultValue(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), ansibleVaultHeader)
+}
+
+func decryptAnsibleVault(value, passphrase string) (string, error) {
+	lines := strings.Fields(value)
+	if len(lines) < 2 {
+		return "", errors.New("failed to parse ansible vault data: no encrypted data after the header")
+	}
+	// The header is $ANSIBLE_VAULT;1.1;AES256, or with a vault ID label at the end for version 1.2
+	header := strings.Split(lines[0], ";")
+	if len(header) < 3 || (header[1] != "1.1" && header[1] != "1.2") {
+		return "", fmt.Errorf("unsupported ansible vault header %s", lines[0])
+	}
+	if header[2] != "AES256" {
+		return "", fmt.Errorf("unsupported ansible vault cipher %s", header[2])
+	}
+
+	envelope, err := hex.DecodeString(strings.Join(lines[1:], ""))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse ansible vault data: %s", err)
+	}
+	parts := strings.Split(string(envelope), "\n")
+	if len(parts) != 3 {
+		return "", errors.New("failed to parse ansible vault data: expected a salt, HMAC and ciphertext")
+	}
+	var decoded [3][]byte
+	for i, part := range parts {
+		if decoded[i], err = hex.DecodeString(part); err != nil {
+			return "", fmt.Errorf("failed to parse ansible vault data: %s", err)
+		}
+	}
+	salt, mac, ciphertext := decoded[0], decoded[1], decoded[2]
+
+	keys, err := pbkdf2.Key(sha256.New, passphrase, salt, ansibleVaultIterations, 2*ansibleVaultKeySize+aes.BlockSize)
+	if err != nil {
+		return "", err
+	}
+	cipherKey, macKey, iv := keys[:ansibleVaultKeySize], keys[ansibleVaultKeySize:2*ansibleVaultKeySize], keys[2*ansibleVaultKeySize:]
+
+	expectedMAC := hmac.New(sha256.New, macKey)
+	expectedMAC.Write(ciphertext)
+	if !hmac.Equal(mac, expectedMAC.Sum(nil)) {
+		return "", errors.New("HMAC mismatch. Was it encrypted with a different vault password?")
+	}
+
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, ciphertext)
+
+	// The plaintext is PKCS#7 padded to the AES block size
+	padding := 0
+	if len(plaintext) > 0 {
+		padding = int(plaintext[len(plaintext)-1])
+	}
+	if padding == 0 || padding > aes.BlockSize || padding > len(plaintext) ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return "", errors.New("failed to parse ansible vault data: invalid padding")
+	}
+	return string(plaintext[:len(plaintext)-padding]), nil
+}
@@ -0,0 +1,139 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// inventoryExtensions are the files loaded from an inventory directory
+var inventoryExtensions = []string{".ini", ".yaml", ".yml", ".toml"}
+
+// inventoryReader loads inventory files, directories and the files they include into a single inventoryDefinition
+type inventoryReader struct {
+	visited map[string]bool      // Absolute paths of files already read so that each file is only loaded once
+	paths   []string             // Every file and directory read, so they can be watched for changes
+	vault   VaultPasswordOptions // Where the encryption password of encrypted inventory files is read from
+}
+
+// readPath reads either a single inventory file or every inventory file and script directly within a directory. The
+// format is only applied to a single file, as the files of a directory are each detected from their extension
+func (r *inventoryReader) readPath(path, format string) (inventoryDefinition, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return inventoryDefinition{}, fmt.Errorf("failed to read inventory: %s", err)
+	}
+	if !info.IsDir() {
+		return r.readFile(path, format)
+	}
+	r.paths = append(r.paths, path) // Files being added to or removed from the directory change its modification time
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return inventoryDefinition{}, fmt.Errorf("failed to read inventory directory: %s", err)
+	}
+	var inv inventoryDefinition
+	for _, entry := range entries { // ReadDir returns entries sorted by filename so the load order is predictable
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !slices.Contains(inventoryExtensions, strings.ToLower(filepath.Ext(name))) {
+			if info, err := entry.Info(); err != nil || !isInventoryScript(info) {
+				continue
+			}
+		}
+		fileInv, err := r.readFile(filepath.Join(path, name), "")
+		if err != nil {
+			return inventoryDefinition{}, err
+		}
+		inv = mergeInventories(inv, fileInv)
+	}
+	return inv, nil
+}
+
+// readFile parses a single inventory file followed by any files it includes
+func (r *inventoryReader) readFile(filename, format string) (inventoryDefinition, error) {
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		return inventoryDefinition{}, fmt.Errorf("failed to resolve inventory file %s: %s", filename, err)
+	}
+	if r.visited[absPath] { // Already loaded through another include or the directory it is in
+		return inventoryDefinition{}, nil
+	}
+	r.visited[absPath] = true
+	r.paths = append(r.paths, absPath)
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return inventoryDefinition{}, fmt.Errorf("failed to read inventory file: %s", err)
+	}
+	var data []byte
+	if format == FormatScript || (format == "" && isInventoryScript(info)) {
+		data, err = runInventoryScript(absPath)
+		format = FormatYAML // JSON is valid yaml
+	} else {
+		data, err = readInventoryFile(filename, r.vault)
+	}
+	if err != nil {
+		return inventoryDefinition{}, fmt.Errorf("failed to read inventory file: %s", err)
+	}
+	format, err = detectFormat(filename, format)
+	if err != nil {
+		return inventoryDefinition{}, err
+	}
+	inv, err := parseInventory(filename, format, data)
+	if err != nil {
+		return inventoryDefinition{}, fmt.Errorf("%s: %s", filename, err)
+	}
+	for i := range inv.hosts {
+		inv.hosts[i].file = filename
+		setVariableFile(inv.hosts[i].vars, filename)
+	}
+	for _, group := range inv.groups {
+		setVariableFile(group.vars, filename)
+	}
+	setVariableFile(inv.vars, filename)
+
+	includes := inv.includes
+	inv.includes = nil
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filename), include)
+		}
+		includedInv, err := r.readPath(include, "")
+		if err != nil {
+			return inventoryDefinition{}, fmt.Errorf("%s: failed to include %s: %s", filename, include, err)
+		}
+		inv = mergeInventories(inv, includedInv)
+	}
+	return inv, nil
+}
+
+func setVariableFile(vars []variable, filename string) {
+	for i := range vars {
+		vars[i].file = filename
+	}
+}
+
+// mergeInventories combines the contents of two inventories. Groups with the same name are combined into one group,
+// with the variables from b taking precedence
+func mergeInventories(a, b inventoryDefinition) inventoryDefinition {
+	a.hosts = append(a.hosts, b.hosts...)
+	a.vars = append(a.vars, b.vars...)
+	for _, group := range b.groups {
+		i := slices.IndexFunc(a.groups, func(existing groupDefinition) bool {
+			return existing.name == group.name
+		})
+		if i == -1 {
+			a.groups = append(a.groups, group)
+			continue
+		}
+		a.groups[i].members = append(a.groups[i].members, group.members...)
+		a.groups[i].children = append(a.groups[i].children, group.children...)
+		a.groups[i].vars = append(a.groups[i].vars, group.vars...)
+	}
+	return a
+}
@@ -0,0 +1,257 @@
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultSectionName = "DEFAULT"
+	allGroupName       = "all"
+	groupVarsSuffix    = ":vars"
+	childrenSuffix     = ":children"
+)
+
+// iniSection is a [section] of an ini file along with the entries defined beneath it
+type iniSection struct {
+	name    string
+	line    int
+	entries []iniEntry
+}
+
+type iniEntry struct {
+	key   string
+	value string
+	bare  bool   // The line had no key-value delimiter, such as a host listed as a member of a group
+	raw   string // The full line, which for a bare entry may also carry inline variables after the key
+	line  int
+}
+
+// parseINI reads an inventory where every [section] is either a host entry named after the host's alias, or a group
+// which lists the aliases of its member hosts on their own lines. Variables shared by a group are defined in a
+// [group:vars] section, [group:children] lists groups whose hosts are also in the group, and [all:vars] applies to
+// every host, as do variables before the first section. Other inventory files can be loaded with include= lines
+// before the first section. Ansible inventories are detected and read with parseAnsibleINI
+func parseINI(data []byte) (inventoryDefinition, error) {
+	sections, err := readINISections(data)
+	if err != nil {
+		return inventoryDefinition{}, fmt.Errorf("failed to load ini data: %s", err)
+	}
+	if isAnsibleINI(sections) {
+		return parseAnsibleINI(sections)
+	}
+
+	var inv inventoryDefinition
+	groupIndexes := make(map[string]int) // Group sections and their :vars sections may come in any order
+	getGroup := func(name string) *groupDefinition {
+		if i, ok := groupIndexes[name]; ok {
+			return &inv.groups[i]
+		}
+		groupIndexes[name] = len(inv.groups)
+		inv.groups = append(inv.groups, groupDefinition{name: name})
+		return &inv.groups[len(inv.groups)-1]
+	}
+
+	for _, section := range sections {
+		if section.name == defaultSectionName {
+			for _, entry := range section.entries {
+				if entry.key == "include" {
+					if entry.value != "" { // An empty include would otherwise include the directory of this file
+						inv.includes = append(inv.includes, entry.value)
+					}
+					continue
+				}
+				// Variables before the first section are defaults that apply to every host, the same as [all:vars]
+				inv.vars = append(inv.vars, variable{key: entry.key, value: entry.value, line: entry.line})
+			}
+			continue
+		}
+
+		if groupName, ok := strings.CutSuffix(section.name, childrenSuffix); ok {
+			group := getGroup(groupName)
+			for _, entry := range section.entries {
+				if !entry.bare {
+					return inventoryDefinition{}, fmt.Errorf("line %d: %s may only list group names", entry.line, section.name)
+				}
+				group.children = append(group.children, entry.key)
+			}
+			continue
+		}
+
+		if groupName, ok := strings.CutSuffix(section.name, groupVarsSuffix); ok {
+			vars, err := iniSectionVars(section)
+			if err != nil {
+				return inventoryDefinition{}, err
+			}
+			if groupName == allGroupName {
+				inv.vars = append(inv.vars, vars...)
+			} else {
+				group := getGroup(groupName)
+				group.vars = append(group.vars, vars...)
+			}
+			continue
+		}
+
+		if !iniSectionIsGroup(section, sections) {
+			vars, err := iniSectionVars(section)
+			if err != nil {
+				return inventoryDefinition{}, err
+			}
+			inv.hosts = append(inv.hosts, hostDefinition{alias: section.name, vars: vars, line: section.line})
+			continue
+		}
+
+		group := getGroup(section.name)
+		for _, entry := range section.entries {
+			if !entry.bare {
+				return inventoryDefinition{}, fmt.Errorf("line %d: group %s may only list host aliases. Variables for the group belong in a [%s%s] section",
+					entry.line, section.name, section.name, groupVarsSuffix)
+			}
+			group.members = append(group.members, entry.key)
+		}
+	}
+	return inv, nil
+}
+
+// iniSectionIsGroup reports whether a section lists member hosts rather than defining a host's variables. A section
+// with its own :vars or :children section is a group even when it has no members yet
+func iniSectionIsGroup(section iniSection, sections []iniSection) bool {
+	for _, entry := range section.entries {
+		if entry.bare {
+			return true
+		}
+	}
+	for _, other := range sections {
+		if other.name == section.name+groupVarsSuffix || other.name == section.name+childrenSuffix {
+			return true
+		}
+	}
+	return false
+}
+
+func iniSectionVars(section iniSection) ([]variable, error) {
+	vars := make([]variable, 0, len(section.entries))
+	for _, entry := range section.entries {
+		if entry.bare {
+			return nil, fmt.Errorf("line %d: expected a key=value pair in section %s but found %s", entry.line, section.name, entry.key)
+		}
+		vars = append(vars, variable{key: entry.key, value: entry.value, line: entry.line})
+	}
+	return vars, nil
+}
+
+// readINISections is a small ini reader that, unlike the ini package, keeps track of line numbers and of lines that
+// have no value at all. Values are unquoted the same way as the ini package so existing inventories read identically
+func readINISections(data []byte) ([]iniSection, error) {
+	sections := []iniSection{{name: defaultSectionName}}
+	current := &sections[0]
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.LastIndex(line, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unclosed section header %s", lineNumber, line)
+			}
+			name := strings.TrimSpace(line[1:end])
+			if name == "" {
+				return nil, fmt.Errorf("line %d: empty section name", lineNumber)
+			}
+			sections = append(sections, iniSection{name: name, line: lineNumber})
+			current = &sections[len(sections)-1]
+			continue
+		}
+
+		delimiter := findINIDelimiter(line)
+		key := line
+		if delimiter != -1 {
+			key = strings.TrimSpace(line[:delimiter])
+		}
+		if delimiter == -1 || strings.ContainsAny(key, " \t") { // A name that may be followed by inline variables
+			current.entries = append(current.entries, iniEntry{key: strings.Fields(line)[0], bare: true, raw: line, line: lineNumber})
+			continue
+		}
+
+		entry := iniEntry{key: key, raw: line, line: lineNumber}
+		value := strings.TrimSpace(line[delimiter+1:])
+		quoted := false
+		for _, quote := range []string{`"""`, "`"} {
+			if !strings.HasPrefix(value, quote) {
+				continue
+			}
+			// Quoted values may span multiple lines, so keep reading until the closing quote
+			value = value[len(quote):]
+			for !strings.Contains(value, quote) {
+				if !scanner.Scan() {
+					return nil, fmt.Errorf("line %d: unclosed %s quoted value for %s", entry.line, quote, entry.key)
+				}
+				lineNumber++
+				value += "\n" + scanner.Text()
+			}
+			value = value[:strings.LastIndex(value, quote)]
+			quoted = true
+			break
+		}
+		if !quoted {
+			value = unquoteINIValue(value)
+		}
+		entry.value = value
+		current.entries = append(current.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// findINIDelimiter returns the index of the first = or : in the line that is not part of a host range such as
+// web[01:40], or -1 if there is none
+func findINIDelimiter(line string) int {
+	depth := 0
+	for i, c := range line {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth = max(0, depth-1)
+		case '=':
+			return i
+		case ':':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// stripINIComment removes an inline comment from a line, which starts at a ; or # that follows whitespace
+func stripINIComment(line string) string {
+	for i := 1; i < len(line); i++ {
+		if (line[i] == ';' || line[i] == '#') && (line[i-1] == ' ' || line[i-1] == '\t') {
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return line
+}
+
+func unquoteINIValue(value string) string {
+	if i := strings.IndexAny(value, "#;"); i > -1 { // Inline comment
+		value = strings.TrimSpace(value[:i])
+	}
+	for _, quote := range []byte{'"', '\''} {
+		if len(value) >= 2 && value[0] == quote && value[len(value)-1] == quote && strings.IndexByte(value[1:], quote) == len(value)-2 {
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
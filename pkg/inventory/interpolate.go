@@ -0,0 +1,33 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// envVarReference matches ${VAR} and ${VAR:-default} references along with $${, which escapes a literal ${
+var envVarReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// interpolateEnv replaces environment variable references in an inventory value. A variable that is unset or empty
+// uses its default if it has one, otherwise it is an error so that a missing variable is not silently ignored
+func interpolateEnv(value string) (string, error) {
+	var err error
+	interpolated := envVarReference.ReplaceAllStringFunc(value, func(reference string) string {
+		if reference == "$${" {
+			return "${"
+		}
+		match := envVarReference.FindStringSubmatch(reference)
+		if envValue := os.Getenv(match[1]); envValue != "" {
+			return envValue
+		}
+		if len(reference) > len(match[1])+3 { // Has a :- default, which may itself be empty
+			return match[2]
+		}
+		if err == nil {
+			err = fmt.Errorf("environment variable %s is not set", match[1])
+		}
+		return reference
+	})
+	return interpolated, err
+}
@@ -1,12 +1,13 @@
 package inventory
 
 import (
+	"errors"
 	"fmt"
-	"golang.org/x/term"
-	"gopkg.in/ini.v1"
-	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Host struct {
@@ -16,81 +17,659 @@ type Host struct {
 	Address           string
 	Port              int
 	SshPrivateKeyFile string
+	// SshPrivateKeyPassphrase decrypts SshPrivateKeyFile. If empty and the key is encrypted, it is prompted for
+	SshPrivateKeyPassphrase string
+	SshCertificateFile      string   // OpenSSH user certificate signed for the private key
+	DisableSshAgent         bool     // Do not authenticate with keys held by the ssh agent
+	IdentityAgent           string   // Socket of the ssh agent to use instead of SSH_AUTH_SOCK
+	Groups                  []string // Names of the groups the host is a member of, in the order the groups were defined
+	Tags                    []string // Labels used to select hosts with a Selector
+	JumpHost                *Host    // Host that must be connected to first in order to reach this host
+	KnownHostsFile          string   // File the host's key is checked against instead of ~/.ssh/known_hosts
+
+	Interval       time.Duration // How often stats are collected, zero for the default
+	CommandTimeout time.Duration // How long each collection command may run for, zero for no limit
+	Metrics        []string      // The Metric* values to collect, or empty for every metric the host can collect
+	DiskMounts     []string      // Paths of the filesystems whose disk usage is collected, or empty for just /
+	Become         string        // How to gain root privileges to collect metrics, BecomeSudo or empty to not gain them
+	BecomePassword string        // Password given to sudo if it asks for one
+	BecomeMetrics  []string      // Metric* values collected with root privileges in addition to the privileged metrics
+}
+
+const (
+	FormatINI  = "ini"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+	// FormatAnsible is only needed when an Ansible inventory cannot be told apart from an ez-monitor one by its contents
+	FormatAnsible = "ansible"
+)
+
+// The metrics that can be listed in a host's metrics variable
+const (
+	MetricCPU     = "cpu"
+	MetricMemory  = "mem"
+	MetricDisk    = "disk"
+	MetricNetwork = "net"
+	// MetricProcessIO is the disk I/O of every process, which only root can read for processes of other users
+	MetricProcessIO = "io"
+)
+
+// privilegedMetrics are collected with commands that need root privileges. They are always collected with sudo, and
+// only collected from hosts that set become unless the host's metrics variable asks for them
+var privilegedMetrics = []string{MetricProcessIO}
+
+// BecomeSudo is the only supported become method, which runs collection commands with sudo
+const BecomeSudo = "sudo"
+
+// metricNames maps every accepted spelling of a metric to its Metric* value
+var metricNames = map[string]string{
+	"cpu":        MetricCPU,
+	"mem":        MetricMemory,
+	"memory":     MetricMemory,
+	"disk":       MetricDisk,
+	"net":        MetricNetwork,
+	"network":    MetricNetwork,
+	"io":         MetricProcessIO,
+	"process_io": MetricProcessIO,
+}
+
+// IsPrivilegedMetric reports whether the metric, one of the Metric* values, can only be collected with root privileges
+func IsPrivilegedMetric(metric string) bool {
+	return slices.Contains(privilegedMetrics, metric)
+}
+
+// CollectsMetric reports whether the metric, one of the Metric* values, should be collected from the host. Without a
+// metrics variable every metric is collected, other than the privileged metrics from hosts that do not set become
+func (h Host) CollectsMetric(metric string) bool {
+	if len(h.Metrics) == 0 {
+		return h.Become != "" || !IsPrivilegedMetric(metric)
+	}
+	return slices.Contains(h.Metrics, metric)
+}
+
+// BecomesForMetric reports whether the metric, one of the Metric* values, should be collected with root privileges,
+// which is the case for the privileged metrics and those listed in become_metrics
+func (h Host) BecomesForMetric(metric string) bool {
+	return h.Become != "" && (IsPrivilegedMetric(metric) || slices.Contains(h.BecomeMetrics, metric))
+}
+
+type LoadOptions struct {
+	// Format forces the inventory to be parsed as one of the Format* values. If empty, the format is detected from the
+	// file extension, falling back to ini
+	Format string
+	// SshConfigFile is the OpenSSH client config used to resolve hosts with the ssh_config_host variable. If empty,
+	// DefaultSshConfigFile is used
+	SshConfigFile string
+	// VaultPassword is where the encryption password for encrypted passwords comes from without prompting for it
+	VaultPassword VaultPasswordOptions
+}
+
+// inventoryDefinition is the contents of an inventory file before any variables have been validated
+type inventoryDefinition struct {
+	hosts    []hostDefinition
+	groups   []groupDefinition
+	vars     []variable // Variables that apply to every host
+	includes []string   // Other inventory files to load, relative to the file that included them
+}
+
+// hostDefinition is a single host entry as read from an inventory file, before its variables have been validated
+type hostDefinition struct {
+	alias string
+	vars  []variable
+	// implicit hosts were only named as a group member, so their alias doubles as their address unless one is set
+	implicit bool
+	file     string
+	line     int // Zero if the format does not provide line numbers
 }
 
-func LoadInventory(filename string) ([]Host, error) {
-	f, err := os.ReadFile(filename)
+// location describes where the host was defined for use in error messages
+func (d hostDefinition) location() string {
+	if d.line == 0 {
+		return d.file
+	}
+	return fmt.Sprintf("%s:%d", d.file, d.line)
+}
+
+type groupDefinition struct {
+	name     string
+	members  []string // Aliases of the hosts in the group
+	children []string // Names of groups whose hosts are also members of this group
+	vars     []variable
+}
+
+type variable struct {
+	key   string
+	value string
+	file  string
+	line  int // Zero if the format does not provide line numbers
+}
+
+func LoadInventory(filename string, opts LoadOptions) ([]Host, error) {
+	hosts, _, err := loadInventory(filename, opts)
+	return hosts, err
+}
+
+// LoadInventoryFiles loads the inventory the same as LoadInventory, also returning the path of every file and directory
+// it was read from so that they can be passed to Watch
+func LoadInventoryFiles(filename string, opts LoadOptions) ([]Host, []string, error) {
+	return loadInventory(filename, opts)
+}
+
+// loadInventory loads the inventory and also returns the paths of every file and directory it was read from
+func loadInventory(filename string, opts LoadOptions) ([]Host, []string, error) {
+	reader := &inventoryReader{visited: make(map[string]bool), vault: opts.VaultPassword}
+	inv, err := reader.readPath(filename, opts.Format)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, nil, err
 	}
 
-	cfg, err := ini.Load(f)
+	hosts, err := buildHosts(inv, &hostBuilder{opts: opts})
 	if err != nil {
-		return nil, fmt.Errorf("failed to load ini data: %s", err)
+		return nil, nil, err
 	}
+	return hosts, reader.paths, nil
+}
 
-	// If we see an encrypted password we will prompt the user for this value and then save it to this var for further passwords
-	var encPassword string
+// parseInventory parses the contents of a single inventory file in the given format
+func parseInventory(filename, format string, data []byte) (inventoryDefinition, error) {
+	switch format {
+	case FormatINI:
+		return parseINI(data)
+	case FormatYAML:
+		return parseYAML(data)
+	case FormatTOML:
+		return parseTOML(data)
+	case FormatAnsible:
+		return parseAnsible(filename, data)
+	}
+	return inventoryDefinition{}, fmt.Errorf("unsupported inventory format %s", format)
+}
 
-	hostMap := make(map[string]Host)
-	for _, section := range cfg.Sections() {
-		hostAlias := section.Name()
-		if hostAlias == ini.DefaultSection {
-			for _, key := range section.Keys() {
-				return nil, fmt.Errorf("variables %s=%s must be defined under a host section", key.Name(), key.Value())
-			}
+// detectFormat returns the explicitly requested format if there is one, otherwise it guesses based off of the file extension
+func detectFormat(filename, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case FormatINI:
+		return FormatINI, nil
+	case FormatYAML, "yml":
+		return FormatYAML, nil
+	case FormatTOML:
+		return FormatTOML, nil
+	case FormatAnsible:
+		return FormatAnsible, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported inventory format %s. Must be one of %s, %s, %s, %s or %s", format, FormatINI, FormatYAML, FormatTOML, FormatAnsible, FormatScript)
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".toml":
+		return FormatTOML, nil
+	default:
+		return FormatINI, nil
+	}
+}
+
+// buildHosts validates the variables of every host definition and converts them into Hosts. The same rules apply
+// regardless of which inventory format the definitions were read from
+func buildHosts(inv inventoryDefinition, builder *hostBuilder) ([]Host, error) {
+	inv, err := expandHostRanges(inv)
+	if err != nil {
+		return nil, err
+	}
+	hosts, hostGroups, err := resolveGroups(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	hostList := make([]Host, 0, len(hosts))
+	errs := findDuplicateHosts(inv) // Every host is checked so that all of the problems in the inventory are reported at once
+	for _, definition := range hosts {
+		host := Host{
+			Alias: definition.alias,
+		}
+
+		// Variables are applied from least to most specific so that host variables override group variables which
+		// in turn override variables for all hosts
+		layers := [][]variable{inv.vars}
+		for _, group := range hostGroups[definition.alias] {
+			host.Groups = append(host.Groups, group.name)
+			layers = append(layers, group.vars)
+		}
+		layers = append(layers, definition.vars)
+
+		if err := builder.applyVariables(&host, mergeVariables(layers...)); err != nil {
+			errs = append(errs, err)
+		}
+		if host.Address == "" && definition.implicit {
+			host.Address = host.Alias
+		}
+		if host.Address == "" {
+			errs = append(errs, newDiagnostic(definition.file, definition.line, "host %s has no address", host.Alias))
+		}
+		if host.Username == "" {
+			errs = append(errs, newDiagnostic(definition.file, definition.line, "host %s has no username", host.Alias))
+		}
+		hostList = append(hostList, host)
+	}
+	errs = append(errs, builder.resolveJumpHosts(hostList)...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return hostList, nil
+}
+
+// findDuplicateHosts reports every host alias that is defined more than once
+func findDuplicateHosts(inv inventoryDefinition) []error {
+	var errs []error
+	firstDefinitions := make(map[string]hostDefinition)
+	for _, definition := range inv.hosts {
+		if first, ok := firstDefinitions[definition.alias]; ok {
+			errs = append(errs, newDiagnostic(definition.file, definition.line, "duplicate host alias %s, first defined at %s", definition.alias, first.location()))
+			continue
+		}
+		firstDefinitions[definition.alias] = definition
+	}
+	return errs
+}
+
+// resolveGroups returns every host in the inventory along with the groups each host belongs to, either directly or
+// through a group's children. A host's groups are ordered from least to most specific so that child group variables
+// take precedence over their parent's. Hosts that are only listed as a group member are added as implicit hosts
+func resolveGroups(inv inventoryDefinition) ([]hostDefinition, map[string][]groupDefinition, error) {
+	var hosts []hostDefinition
+	hostIndexes := make(map[string]int)
+	for _, definition := range inv.hosts {
+		if _, ok := hostIndexes[definition.alias]; ok { // Reported by findDuplicateHosts
 			continue
 		}
-		if _, ok := hostMap[hostAlias]; ok {
-			return nil, fmt.Errorf("duplicate host section: %s", hostAlias)
+		hostIndexes[definition.alias] = len(hosts)
+		hosts = append(hosts, definition)
+	}
+
+	groupIndexes := make(map[string]int)
+	for i, group := range inv.groups {
+		if group.name == allGroupName {
+			return nil, nil, fmt.Errorf("group name %s is reserved for variables that apply to every host", allGroupName)
 		}
+		if _, ok := hostIndexes[group.name]; ok {
+			return nil, nil, fmt.Errorf("group %s has the same name as a host", group.name)
+		}
+		groupIndexes[group.name] = i
+	}
 
-		host := Host{
-			Alias: hostAlias,
+	parents := make(map[string][]string)
+	for _, group := range inv.groups {
+		for _, child := range group.children {
+			if _, ok := groupIndexes[child]; !ok {
+				return nil, nil, fmt.Errorf("group %s has the child group %s which is not defined in the inventory", group.name, child)
+			}
+			parents[child] = append(parents[child], group.name)
+		}
+	}
+
+	// A group's depth is how many parents it has above it, which decides the order its variables are applied in
+	depths := make(map[string]int)
+	var groupDepth func(name string, visiting map[string]bool) (int, error)
+	groupDepth = func(name string, visiting map[string]bool) (int, error) {
+		if depth, ok := depths[name]; ok {
+			return depth, nil
+		}
+		if visiting[name] {
+			return 0, fmt.Errorf("group %s is its own ancestor through its child groups", name)
+		}
+		visiting[name] = true
+		depth := 0
+		for _, parent := range parents[name] {
+			parentDepth, err := groupDepth(parent, visiting)
+			if err != nil {
+				return 0, err
+			}
+			depth = max(depth, parentDepth+1)
+		}
+		depths[name] = depth
+		return depth, nil
+	}
+
+	memberships := make(map[string]map[string]bool)
+	var addMembership func(alias, groupName string)
+	addMembership = func(alias, groupName string) {
+		if memberships[alias][groupName] {
+			return
+		}
+		if memberships[alias] == nil {
+			memberships[alias] = make(map[string]bool)
+		}
+		memberships[alias][groupName] = true
+		for _, parent := range parents[groupName] {
+			addMembership(alias, parent)
 		}
-		for _, key := range section.Keys() {
-			switch key.Name() {
-			case "username":
-				host.Username = key.Value()
+	}
+
+	for _, group := range inv.groups {
+		if _, err := groupDepth(group.name, make(map[string]bool)); err != nil {
+			return nil, nil, err
+		}
+		for _, member := range group.members {
+			if _, ok := hostIndexes[member]; !ok {
+				hostIndexes[member] = len(hosts)
+				hosts = append(hosts, hostDefinition{alias: member, implicit: true})
+			}
+			addMembership(member, group.name)
+		}
+	}
+
+	hostGroups := make(map[string][]groupDefinition)
+	for alias, groupNames := range memberships {
+		for _, group := range inv.groups {
+			if groupNames[group.name] {
+				hostGroups[alias] = append(hostGroups[alias], group)
+			}
+		}
+		slices.SortStableFunc(hostGroups[alias], func(a, b groupDefinition) int {
+			return depths[a.name] - depths[b.name]
+		})
+	}
+	return hosts, hostGroups, nil
+}
+
+// hostBuilder holds the state shared while converting every host definition of an inventory
+type hostBuilder struct {
+	opts LoadOptions
+	// The ssh config is only read if a host references it
+	sshCfg *sshConfig
+	// Decrypted passwords by their encrypted value, as a password set on a group is decrypted for each of its hosts and
+	// deriving the key is deliberately slow. Holds both $EZ_MONITOR_ENCRYPTED; and Ansible Vault values
+	decrypted map[string]string
+	// checkFiles enables checks that files referenced by the inventory can be read, which are otherwise left until
+	// connecting to the host
+	checkFiles bool
+	// The jump_host variable of each host by alias, which can only be resolved once every host has been built as it
+	// may name other hosts
+	jumpHosts map[string]variable
+}
+
+// decryptVariable returns the value of a variable that may hold an encrypted secret. Problems with the value itself are
+// returned as a Diagnostic, while any other error means the encryption password could not be read
+func (b *hostBuilder) decryptVariable(v variable, alias string) (string, error) {
+	for _, header := range []string{ezMonitorEncDelimiter, ansibleVaultHeader} {
+		if v.value == strings.TrimSuffix(header, ";") { // The rest was read as an ini comment
+			return "", newDiagnostic(v.file, v.line, "the encrypted %s for host %s must be wrapped in backticks", v.key, alias)
+		}
+	}
+	if !strings.HasPrefix(v.value, ezMonitorEncDelimiter) && !isAnsibleVaultValue(v.value) {
+		return v.value, nil
+	}
+
+	encPassword, err := encryptionPassword(b.opts.VaultPassword)
+	if err != nil {
+		return "", err
+	}
+	value, err := b.decrypt(v.value, encPassword)
+	if err != nil {
+		return "", newDiagnostic(v.file, v.line, "failed to decrypt %s for host %s: %s", v.key, alias, err)
+	}
+	return value, nil
+}
+
+func (b *hostBuilder) decrypt(value, encPassword string) (string, error) {
+	if password, ok := b.decrypted[value]; ok {
+		return password, nil
+	}
+	decryptValue := decrypt
+	if isAnsibleVaultValue(value) {
+		decryptValue = decryptAnsibleVault
+	}
+	password, err := decryptValue(value, encPassword)
+	if err != nil {
+		return "", err
+	}
+	if b.decrypted == nil {
+		b.decrypted = make(map[string]string)
+	}
+	b.decrypted[value] = password
+	return password, nil
+}
+
+// secretVariables hold passwords and passphrases, which may be encrypted
+var secretVariables = []string{"password", "ssh_private_key_passphrase", "become_password"}
+
+// applyVariables sets the fields of the host from its variables. Every invalid variable is reported rather than only
+// the first, each as a Diagnostic pointing at where the variable was set
+func (b *hostBuilder) applyVariables(host *Host, vars []variable) error {
+	var errs []error
+	var sshConfigHost *variable
+	for _, v := range vars {
+		var err error
+		if !slices.Contains(secretVariables, v.key) { // Plaintext secrets may contain ${ and are taken literally
+			v.value, err = interpolateEnv(v.value)
+			if err != nil {
+				errs = append(errs, newDiagnostic(v.file, v.line, "%s for variable %s of host %s", err, v.key, host.Alias))
+				continue
+			}
+		}
+		// Unlike $EZ_MONITOR_ENCRYPTED; values, any variable may be vault encrypted
+		if isAnsibleVaultValue(v.value) || v.value == strings.TrimSuffix(ansibleVaultHeader, ";") {
+			v.value, err = b.decryptVariable(v, host.Alias)
+			if err != nil {
+				if !errors.As(err, &Diagnostic{}) {
+					return err
+				}
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		switch v.key {
+		case "username":
+			host.Username = v.value
+		case "password", "ssh_private_key_passphrase", "become_password":
+			value, err := b.decryptVariable(v, host.Alias)
+			if err != nil {
+				if !errors.As(err, &Diagnostic{}) {
+					return err
+				}
+				errs = append(errs, err)
+				continue
+			}
+			switch v.key {
 			case "password":
-				if strings.HasPrefix(key.Value(), ezMonitorEncDelimiter) {
-					if encPassword == "" {
-						fmt.Println("Please enter your encryption password to decrypt the host passwords in this file.")
-						encPasswordBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
-						fmt.Println() // Print a newline after password input
-						if err != nil {
-							return nil, fmt.Errorf("failed to read encryption password: %s", err)
-						}
-						encPassword = string(encPasswordBytes)
-					}
-					host.Password, err = decrypt(key.Value(), encPassword)
-					if err != nil {
-						return nil, fmt.Errorf("failed to decrypt password: %s", err)
-					}
-				} else {
-					host.Password = key.Value()
+				host.Password = value
+			case "ssh_private_key_passphrase":
+				host.SshPrivateKeyPassphrase = value
+			default:
+				host.BecomePassword = value
+			}
+		case "address":
+			host.Address = v.value
+		case "port":
+			port, err := strconv.Atoi(v.value)
+			if err != nil || port < 1 || port > 65535 {
+				errs = append(errs, newDiagnostic(v.file, v.line, "invalid port %s for host %s. Must be a number between 1 and 65535", v.value, host.Alias))
+				continue
+			}
+			host.Port = port
+		case "ssh_private_key_file":
+			host.SshPrivateKeyFile = v.value
+			if b.checkFiles {
+				if err := checkReadable(v.value); err != nil {
+					errs = append(errs, newDiagnostic(v.file, v.line, "ssh_private_key_file for host %s cannot be read: %s", host.Alias, err))
 				}
-			case "address":
-				host.Address = key.Value()
-			case "port":
-				host.Port, err = strconv.Atoi(key.Value())
-				if err != nil {
-					return nil, fmt.Errorf("invalid port in section %s: %s", hostAlias, err)
+			}
+		case "ssh_certificate_file":
+			host.SshCertificateFile = v.value
+			if b.checkFiles {
+				if err := checkReadable(v.value); err != nil {
+					errs = append(errs, newDiagnostic(v.file, v.line, "ssh_certificate_file for host %s cannot be read: %s", host.Alias, err))
+				}
+			}
+		case "known_hosts_file":
+			host.KnownHostsFile = v.value
+		case "tags":
+			host.Tags = splitList(v.value)
+		case "interval", "command_timeout":
+			duration, err := parseDuration(v.value)
+			if err != nil {
+				errs = append(errs, newDiagnostic(v.file, v.line, "invalid %s %s for host %s. Must be a duration such as 5s or 1m", v.key, v.value, host.Alias))
+				continue
+			}
+			if v.key == "interval" {
+				host.Interval = duration
+			} else {
+				host.CommandTimeout = duration
+			}
+		case "metrics", "become_metrics":
+			var metrics []string
+			for _, name := range splitList(v.value) {
+				metric, ok := metricNames[strings.ToLower(name)]
+				if !ok {
+					errs = append(errs, newDiagnostic(v.file, v.line, "unknown metric %s for host %s. Must be one of %s, %s, %s, %s or %s",
+						name, host.Alias, MetricCPU, MetricMemory, MetricDisk, MetricNetwork, MetricProcessIO))
+					continue
+				}
+				if !slices.Contains(metrics, metric) {
+					metrics = append(metrics, metric)
 				}
-			case "ssh_private_key_file":
-				host.SshPrivateKeyFile = key.Value()
+			}
+			if v.key == "metrics" {
+				host.Metrics = metrics
+			} else {
+				host.BecomeMetrics = metrics
+			}
+		case "become":
+			// Booleans are accepted as Ansible inventories set ansible_become to yes or true, with sudo as the default
+			// become method
+			switch strings.ToLower(v.value) {
+			case BecomeSudo, "true", "yes":
+				host.Become = BecomeSudo
+			case "", "false", "no":
+				host.Become = ""
 			default:
-				return nil, fmt.Errorf("unknown variable %s for host %s", key.Name(), hostAlias)
+				errs = append(errs, newDiagnostic(v.file, v.line, "unsupported become %s for host %s. Must be %s or false", v.value, host.Alias, BecomeSudo))
+			}
+		case "disk_mounts":
+			host.DiskMounts = splitList(v.value)
+			for _, mount := range host.DiskMounts {
+				if !strings.HasPrefix(mount, "/") {
+					errs = append(errs, newDiagnostic(v.file, v.line, "disk mount %s for host %s must be an absolute path", mount, host.Alias))
+				}
+			}
+		case "ssh_agent":
+			useAgent, err := strconv.ParseBool(v.value)
+			if err != nil {
+				errs = append(errs, newDiagnostic(v.file, v.line, "invalid ssh_agent %s for host %s. Must be true or false", v.value, host.Alias))
+				continue
 			}
+			host.DisableSshAgent = !useAgent
+		case "identity_agent":
+			host.IdentityAgent, host.DisableSshAgent = parseIdentityAgent(v.value)
+		case "jump_host":
+			if b.jumpHosts == nil {
+				b.jumpHosts = make(map[string]variable)
+			}
+			b.jumpHosts[host.Alias] = v
+		case "ssh_config_host":
+			sshConfigHost = &v
+		default:
+			errs = append(errs, newDiagnostic(v.file, v.line, "unknown variable %s for host %s", v.key, host.Alias))
 		}
-		hostMap[hostAlias] = host
 	}
 
-	hostList := make([]Host, 0, len(hostMap))
-	for _, host := range hostMap {
-		hostList = append(hostList, host)
+	if sshConfigHost != nil {
+		if b.sshCfg == nil {
+			sshConfigFile := b.opts.SshConfigFile
+			if sshConfigFile == "" {
+				sshConfigFile = DefaultSshConfigFile
+			}
+			var err error
+			b.sshCfg, err = loadSshConfig(sshConfigFile)
+			if err != nil {
+				return errors.Join(append(errs, newDiagnostic(sshConfigHost.file, sshConfigHost.line, "%s", err))...)
+			}
+		}
+		sshConfigInfo, err := b.sshCfg.resolveHost(sshConfigHost.value, 0)
+		if err != nil {
+			return errors.Join(append(errs, newDiagnostic(sshConfigHost.file, sshConfigHost.line, "%s", err))...)
+		}
+		*host = mergeSshConfigHost(*host, sshConfigInfo)
 	}
+	return errors.Join(errs...)
+}
 
-	return hostList, nil
+// mergeSshConfigHost fills in any connection information that was not set in the inventory from the matching
+// ssh config entry. Values in the inventory always take precedence
+func mergeSshConfigHost(host, sshConfigInfo Host) Host {
+	if host.Address == "" {
+		host.Address = sshConfigInfo.Address
+	}
+	if host.Username == "" {
+		host.Username = sshConfigInfo.Username
+	}
+	if host.Port == 0 {
+		host.Port = sshConfigInfo.Port
+	}
+	if host.SshPrivateKeyFile == "" {
+		host.SshPrivateKeyFile = sshConfigInfo.SshPrivateKeyFile
+	}
+	if host.SshCertificateFile == "" {
+		host.SshCertificateFile = sshConfigInfo.SshCertificateFile
+	}
+	if host.IdentityAgent == "" && !host.DisableSshAgent {
+		host.IdentityAgent = sshConfigInfo.IdentityAgent
+		host.DisableSshAgent = sshConfigInfo.DisableSshAgent
+	}
+	if host.KnownHostsFile == "" {
+		host.KnownHostsFile = sshConfigInfo.KnownHostsFile
+	}
+	if host.JumpHost == nil {
+		host.JumpHost = sshConfigInfo.JumpHost
+	}
+	return host
+}
+
+// splitList splits a comma separated value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseDuration parses a positive duration such as 5s or 1m30s. A plain number is taken as seconds
+func parseDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, atoiErr := strconv.Atoi(value)
+		if atoiErr != nil {
+			return 0, err
+		}
+		duration = time.Duration(seconds) * time.Second
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
+	}
+	return duration, nil
+}
+
+// mergeVariables flattens layers of variables into a single list where a key in a later layer replaces the value of the
+// same key in an earlier one
+func mergeVariables(layers ...[]variable) []variable {
+	var merged []variable
+	keyIndexes := make(map[string]int)
+	for _, layer := range layers {
+		for _, v := range layer {
+			if i, ok := keyIndexes[v.key]; ok {
+				merged[i] = v
+				continue
+			}
+			keyIndexes[v.key] = len(merged)
+			merged = append(merged, v)
+		}
+	}
+	return merged
 }
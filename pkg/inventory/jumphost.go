@@ -0,0 +1,90 @@
+package inventory
+
+import (
+	"slices"
+	"strings"
+)
+
+// resolveJumpHosts sets the JumpHost of every host with a jump_host variable, overriding any ProxyJump from the ssh
+// config. A jump_host is a comma separated chain of jump hosts where, as with ProxyJump, the first is connected to
+// directly and every other is reached through the one before it. Each entry is either the alias of another host in
+// the inventory, whose own jump_host is followed, or a [user@]host[:port] that is connected to with the credentials
+// of the host behind it. A jump_host of none connects directly
+func (b *hostBuilder) resolveJumpHosts(hosts []Host) []error {
+	indexes := make(map[string]int, len(hosts))
+	for i, host := range hosts {
+		indexes[host.Alias] = i
+	}
+
+	var errs []error
+	resolved := make(map[string]bool)
+	var resolve func(i int, path []string) error
+	resolve = func(i int, path []string) error {
+		host := &hosts[i]
+		v, ok := b.jumpHosts[host.Alias]
+		if !ok || resolved[host.Alias] {
+			return nil
+		}
+		for j, alias := range path {
+			if alias == host.Alias {
+				cycle := append(slices.Clone(path[j:]), host.Alias)
+				return newDiagnostic(v.file, v.line, "jump_host of host %s loops back on itself: %s", host.Alias, strings.Join(cycle, " -> "))
+			}
+		}
+		defer func() { resolved[host.Alias] = true }() // Also set on error so that a loop is only reported once
+		path = append(slices.Clone(path), host.Alias)
+
+		host.JumpHost = nil
+		if strings.ToLower(v.value) == "none" {
+			return nil
+		}
+		var previous *Host
+		for _, spec := range splitList(v.value) {
+			var jumpHost Host
+			if j, ok := indexes[spec]; ok {
+				if err := resolve(j, path); err != nil {
+					return err
+				}
+				jumpHost = hosts[j]
+			} else {
+				username, address, port, err := parseJumpHostSpec(spec)
+				if err != nil {
+					return newDiagnostic(v.file, v.line, "host %s: %s", host.Alias, err)
+				}
+				jumpHost = jumpHostCredentials(*host)
+				jumpHost.Alias, jumpHost.Address, jumpHost.Port = spec, address, port
+				if username != "" {
+					jumpHost.Username = username
+				}
+			}
+			if previous != nil { // Hosts later in the chain are reached through the earlier ones
+				jumpHost.JumpHost = previous
+			}
+			previous = &jumpHost
+		}
+		host.JumpHost = previous
+		return nil
+	}
+
+	for i := range hosts {
+		if err := resolve(i, nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// jumpHostCredentials returns a host with only the credentials of the given host, for connecting to a jump host that
+// is not in the inventory
+func jumpHostCredentials(host Host) Host {
+	return Host{
+		Username:                host.Username,
+		Password:                host.Password,
+		SshPrivateKeyFile:       host.SshPrivateKeyFile,
+		SshPrivateKeyPassphrase: host.SshPrivateKeyPassphrase,
+		SshCertificateFile:      host.SshCertificateFile,
+		DisableSshAgent:         host.DisableSshAgent,
+		IdentityAgent:           host.IdentityAgent,
+		KnownHostsFile:          host.KnownHostsFile,
+	}
+}
@@ -3,45 +3,77 @@ package inventory
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"golang.org/x/crypto/scrypt"
 	"golang.org/x/term"
-	"gopkg.in/ini.v1"
 	"os"
+	"slices"
 	"strings"
 )
 
 const ezMonitorEncDelimiter = "$EZ_MONITOR_ENCRYPTED;"
 
-func BeginPasswordEncryptFlow(hostToAddEncryptedPassword, filename string) error {
-	cfg, err := ini.Load(filename)
+func BeginPasswordEncryptFlow(hostToAddEncryptedPassword, filename, format string) error {
+	return beginEncryptFlow(hostToAddEncryptedPassword, filename, format, "password", "password")
+}
+
+// BeginKeyPassphraseEncryptFlow encrypts the passphrase of a host's ssh_private_key_file in the same way as
+// BeginPasswordEncryptFlow encrypts its password
+func BeginKeyPassphraseEncryptFlow(hostToAddEncryptedPassphrase, filename, format string) error {
+	return beginEncryptFlow(hostToAddEncryptedPassphrase, filename, format, "ssh_private_key_passphrase", "private key passphrase")
+}
+
+// BeginBecomePasswordEncryptFlow encrypts the sudo password of a host in the same way as BeginPasswordEncryptFlow
+// encrypts its password
+func BeginBecomePasswordEncryptFlow(hostToAddEncryptedPassword, filename, format string) error {
+	return beginEncryptFlow(hostToAddEncryptedPassword, filename, format, "become_password", "sudo password")
+}
+
+// beginEncryptFlow prompts for a secret and an encryption password, then saves the encrypted secret under key in the
+// host's section. description is how the secret is referred to in the prompts
+func beginEncryptFlow(hostToAddEncryptedPassword, filename, format, key, description string) error {
+	if content, err := os.ReadFile(filename); err == nil && isEncryptedInventory(content) {
+		return fmt.Errorf("%s is an encrypted inventory so its %ss do not need to be encrypted separately. "+
+			"Use ez-monitor inventory edit to change it", filename, description)
+	}
+	if err := checkEncryptableFormat(filename, format, description); err != nil {
+		return err
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %s", err)
+	}
+	sections, err := readINISections(content)
 	if err != nil {
 		return fmt.Errorf("failed to load ini data: %s", err)
 	}
-	hostSection, err := cfg.GetSection(hostToAddEncryptedPassword)
-	if err != nil { // This func only returns an error if the section does not exist
+	if iniGroupSection(sections, hostToAddEncryptedPassword) != nil {
+		return fmt.Errorf("%s is a group in %s. An encrypted %s can only be added to a host", hostToAddEncryptedPassword, filename, description)
+	}
+	hostSection := iniHostSection(sections, hostToAddEncryptedPassword)
+	if hostSection == nil && !isINIGroupMember(sections, hostToAddEncryptedPassword) {
 		fmt.Printf("There is currently no entry in your inventory file for the host %s\n", hostToAddEncryptedPassword)
 		fmt.Println("Would you like an entry to be added for this host? [y/n]")
 		err = exitIfNoResponse()
 		if err != nil {
 			return err
 		}
-	} else {
-		if hostSection.HasKey("password") {
-			fmt.Printf("There is already a password entry in your inventory file for the host %s\n", hostToAddEncryptedPassword)
-			fmt.Println("Would you like to replace the password entry for this host? [y/n]")
-			err = exitIfNoResponse()
-			if err != nil {
-				return err
-			}
+	} else if hostSection != nil && slices.ContainsFunc(hostSection.entries, func(entry iniEntry) bool { return entry.key == key }) {
+		fmt.Printf("There is already a %s entry in your inventory file for the host %s\n", description, hostToAddEncryptedPassword)
+		fmt.Printf("Would you like to replace the %s entry for this host? [y/n]\n", description)
+		err = exitIfNoResponse()
+		if err != nil {
+			return err
 		}
 	}
 
-	fmt.Printf("Enter the password you would like to set for the host %s:\n", hostToAddEncryptedPassword)
+	fmt.Printf("Enter the %s you would like to set for the host %s:\n", description, hostToAddEncryptedPassword)
 	hostPasswordBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
 	if err != nil {
-		return fmt.Errorf("failed to read host password: %s", err)
+		return fmt.Errorf("failed to read host %s: %s", description, err)
 	}
 	hostPassword := string(hostPasswordBytes)
 
@@ -53,17 +85,17 @@ func BeginPasswordEncryptFlow(hostToAddEncryptedPassword, filename string) error
 	}
 	encPassword := string(encPasswordBytes)
 
-	err = verifyIfDifferentEncPassword(encPassword, hostToAddEncryptedPassword, cfg)
+	err = verifyIfDifferentEncPassword(encPassword, hostToAddEncryptedPassword, sections)
 	if err != nil {
 		return err
 	}
 
 	encryptedHostPassword, err := encrypt(hostPassword, encPassword)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt password: %s", err)
+		return fmt.Errorf("failed to encrypt %s: %s", description, err)
 	}
 
-	err = addOrReplacePasswordValue(filename, hostToAddEncryptedPassword, encryptedHostPassword, cfg)
+	err = addOrReplacePasswordValue(filename, hostToAddEncryptedPassword, key, encryptedHostPassword)
 	if err != nil {
 		return fmt.Errorf("failed to save ini data: %s", err)
 	}
@@ -71,22 +103,66 @@ func BeginPasswordEncryptFlow(hostToAddEncryptedPassword, filename string) error
 	return nil
 }
 
+// checkEncryptableFormat refuses inventories that are not ez-monitor ini files, as the encrypted value is written as
+// an ini key=value line and would corrupt any other format
+func checkEncryptableFormat(filename, format, description string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read inventory file: %s", err)
+	}
+	if format == FormatScript || (format == "" && isInventoryScript(info)) {
+		return fmt.Errorf("%s is an inventory script so an encrypted %s cannot be added to it", filename, description)
+	}
+	if format, err = detectFormat(filename, format); err != nil {
+		return err
+	}
+	if format == FormatINI {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("failed to read file: %s", err)
+		}
+		sections, err := readINISections(content)
+		if err != nil {
+			return fmt.Errorf("failed to load ini data: %s", err)
+		}
+		if !isAnsibleINI(sections) {
+			return nil
+		}
+		format = FormatAnsible
+	}
+	return fmt.Errorf("an encrypted %s can only be added to an ini inventory but %s is in the %s format. Encrypt the "+
+		"whole inventory with ez-monitor inventory encrypt instead", description, filename, format)
+}
+
+// Passwords are encrypted with AES-GCM using a key derived from the encryption password with scrypt. Each password
+// has its own random salt and nonce and is stored as
+//
+//	$EZ_MONITOR_ENCRYPTED;v2;<hex salt>;<hex nonce and ciphertext>
+//
+// Passwords encrypted by earlier versions have no version and used a SHA-256 of the encryption password as the key
+// with a zeroed nonce. They can still be decrypted but should be upgraded with the secrets migrate command
+const (
+	encryptionVersion = "v2"
+	saltSize          = 16
+	scryptN           = 1 << 15
+	scryptR           = 8
+	scryptP           = 1
+)
+
 func encrypt(data, passphrase string) (string, error) {
-	key := sha256.Sum256([]byte(passphrase)) // Derive a 256-bit key from the passphrase
-	block, err := aes.NewCipher(key[:])
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := deriveKey(passphrase, salt)
 	if err != nil {
 		return "", err
 	}
-
-	// Use AES-GCM for encryption
-	aesGCM, err := cipher.NewGCM(block)
+	ciphertext, err := seal(key, []byte(data))
 	if err != nil {
 		return "", err
 	}
-
-	nonce := make([]byte, aesGCM.NonceSize()) // Generate a random nonce
-	ciphertext := aesGCM.Seal(nonce, nonce, []byte(data), nil)
-	return fmt.Sprintf("%s%s", ezMonitorEncDelimiter, hex.EncodeToString(ciphertext)), nil
+	return fmt.Sprintf("%s%s;%s;%s", ezMonitorEncDelimiter, encryptionVersion, hex.EncodeToString(salt), hex.EncodeToString(ciphertext)), nil
 }
 
 func decrypt(encryptedData, passphrase string) (string, error) {
@@ -95,30 +171,78 @@ func decrypt(encryptedData, passphrase string) (string, error) {
 		return "", fmt.Errorf("failed to parse encrypted data")
 	}
 
-	key := sha256.Sum256([]byte(passphrase))
-	block, err := aes.NewCipher(key[:])
+	var key []byte
+	if versioned, ok := strings.CutPrefix(parsedEncryptedData, encryptionVersion+";"); ok {
+		encodedSalt, encodedCiphertext, found := strings.Cut(versioned, ";")
+		if !found {
+			return "", fmt.Errorf("failed to parse encrypted data: missing salt")
+		}
+		salt, err := hex.DecodeString(encodedSalt)
+		if err != nil {
+			return "", err
+		}
+		key, err = deriveKey(passphrase, salt)
+		if err != nil {
+			return "", err
+		}
+		parsedEncryptedData = encodedCiphertext
+	} else if strings.Contains(parsedEncryptedData, ";") {
+		return "", fmt.Errorf("unsupported encrypted data version %s", strings.SplitN(parsedEncryptedData, ";", 2)[0])
+	} else {
+		legacyKey := sha256.Sum256([]byte(passphrase))
+		key = legacyKey[:]
+	}
+
+	data, err := hex.DecodeString(parsedEncryptedData)
 	if err != nil {
 		return "", err
 	}
-
-	aesGCM, err := cipher.NewGCM(block)
+	plaintext, err := open(key, data)
 	if err != nil {
 		return "", err
 	}
 
-	data, err := hex.DecodeString(parsedEncryptedData)
+	return strings.TrimSpace(string(plaintext)), nil
+}
+
+// deriveKey derives the AES key for the current encryption format from the encryption password
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the returned ciphertext
+func seal(key, plaintext []byte) ([]byte, error) {
+	aesGCM, err := newGCM(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aesGCM.Seal(nonce, nonce, plaintext, nil), nil
+}
 
+// open decrypts data produced by seal
+func open(key, data []byte) ([]byte, error) {
+	aesGCM, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
 	nonceSize := aesGCM.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("failed to parse encrypted data: too short")
+	}
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
+	return aesGCM.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	return strings.TrimSpace(string(plaintext)), nil
+	return cipher.NewGCM(block)
 }
 
 func exitIfNoResponse() error {
@@ -135,18 +259,21 @@ func exitIfNoResponse() error {
 
 // verifyIfDifferentEncPassword will check if any encrypted passwords in the file were encrypted with a different password
 // than what is provided to the function
-func verifyIfDifferentEncPassword(encPass, newHostToEnc string, cfg *ini.File) error {
+func verifyIfDifferentEncPassword(encPass, newHostToEnc string, sections []iniSection) error {
 	var hostsWithDifferentEncKeys []string
-	for _, section := range cfg.Sections() {
-		if section.Name() == newHostToEnc {
+	for _, section := range sections {
+		if section.name == newHostToEnc {
 			continue
 		}
-		if section.HasKey("password") {
-			passInFile := section.Key("password").Value()
-			if strings.HasPrefix(passInFile, ezMonitorEncDelimiter) {
-				_, err := decrypt(passInFile, encPass)
+		for _, entry := range section.entries {
+			if !slices.Contains(secretVariables, entry.key) {
+				continue
+			}
+			if strings.HasPrefix(entry.value, ezMonitorEncDelimiter) {
+				_, err := decrypt(entry.value, encPass)
 				if err != nil {
-					hostsWithDifferentEncKeys = append(hostsWithDifferentEncKeys, section.Name())
+					hostsWithDifferentEncKeys = append(hostsWithDifferentEncKeys, section.name)
+					break
 				}
 			}
 		}
@@ -163,66 +290,75 @@ func verifyIfDifferentEncPassword(encPass, newHostToEnc string, cfg *ini.File) e
 	return nil
 }
 
-// addOrReplacePasswordValue is a customer implementation seperate from the ini package.
-// This is necessary to preserve the files formatting and comments as the ini package will reformat
-// The file if used to save a new config when using config.SaveTo()
-// This function which will perform the following actions
-// 1. Load in the ini inventory file
-// 2. Add or replace the password for the appropriate host. If the host does not exist, it will add a host value at the bottom of the file
-// 3. Replace the file with the new contents
-func addOrReplacePasswordValue(filename, hostToAddEncryptedPassword, encryptedHostPassword string, cfg *ini.File) error {
-	content, err := os.ReadFile(filename)
-	if err != nil {
-		return fmt.Errorf("failed to read file: %s", err)
+// iniHostSection returns the section that defines the variables of the host, or nil if it has none. A host that is
+// only listed as a member of a group has no section of its own
+func iniHostSection(sections []iniSection, alias string) *iniSection {
+	for i, section := range sections {
+		if section.name == alias && !iniSectionIsGroup(section, sections) {
+			return &sections[i]
+		}
 	}
+	return nil
+}
 
-	lines := strings.Split(string(content), "\n")
-	var updatedLines []string
-	sectionHeader := "[" + hostToAddEncryptedPassword + "]"
-	newPasswordLine := fmt.Sprintf("password=`%s`", encryptedHostPassword)
-
-	if !cfg.HasSection(hostToAddEncryptedPassword) {
-		updatedLines = append(lines, sectionHeader, newPasswordLine)
-	} else {
-		for i := 0; i < len(lines); i++ {
-			trimmedLine := strings.TrimSpace(lines[i])
-
-			// Check if the current line is the section header
-			if trimmedLine == sectionHeader {
-				updatedLines = append(updatedLines, lines[i])
-				iniPkgSection, _ := cfg.GetSection(hostToAddEncryptedPassword) // err only returned if does not exist
-
-				if iniPkgSection.HasKey("password") { // Replace password if it exists
-					for i++; i < len(lines); i++ {
-						trimmedLine = strings.TrimSpace(lines[i])
-						if strings.HasPrefix(trimmedLine, "password") {
-							updatedLines = append(updatedLines, newPasswordLine)
-							break
-						} else {
-							updatedLines = append(updatedLines, lines[i])
-						}
-					}
-				} else {
-					updatedLines = append(updatedLines, newPasswordLine)
-				}
+// iniGroupSection returns the section that lists the members of the named group, or nil if there is no such group
+func iniGroupSection(sections []iniSection, name string) *iniSection {
+	for i, section := range sections {
+		if section.name == name && iniSectionIsGroup(section, sections) {
+			return &sections[i]
+		}
+	}
+	return nil
+}
 
-			} else { // Continue adding any line not in the section
-				updatedLines = append(updatedLines, lines[i])
+// isINIGroupMember reports whether the host is listed as a member of any group
+func isINIGroupMember(sections []iniSection, alias string) bool {
+	for _, section := range sections {
+		if section.name == defaultSectionName || strings.HasSuffix(section.name, groupVarsSuffix) || strings.HasSuffix(section.name, childrenSuffix) {
+			continue
+		}
+		for _, entry := range section.entries {
+			if entry.bare && entry.key == alias {
+				return true
 			}
 		}
-
 	}
+	return false
+}
 
-	// Write the updated content back to the file
-	stat, err := os.Stat(filename)
+// addOrReplacePasswordValue is a custom implementation that edits the file line by line, using the same sections as
+// parseINI, so that the file's formatting and comments are preserved. It performs the following actions
+// 1. Read the sections of the ini inventory file
+// 2. Replace the password, or other encrypted key, of the host if it has one, or add it beneath the host's section header
+// 3. If the host has no section, add one at the bottom of the file. A host that was only listed as a group member used
+// its alias as its address, which the new section keeps as the section would otherwise replace the implicit host
+// 4. Replace the file with the new contents
+func addOrReplacePasswordValue(filename, hostToAddEncryptedPassword, key, encryptedHostPassword string) error {
+	content, err := os.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf("failed to stat file: %s", err)
+		return fmt.Errorf("failed to read file: %s", err)
 	}
-
-	err = os.WriteFile(filename, []byte(strings.Join(updatedLines, "\n")), stat.Mode())
+	sections, err := readINISections(content)
 	if err != nil {
-		return fmt.Errorf("failed to write updated file: %s", err)
+		return err
 	}
 
-	return nil
+	lines := strings.Split(string(content), "\n")
+	newPasswordLine := fmt.Sprintf("%s=`%s`", key, encryptedHostPassword)
+
+	hostSection := iniHostSection(sections, hostToAddEncryptedPassword)
+	if hostSection == nil {
+		lines = append(lines, "["+hostToAddEncryptedPassword+"]")
+		if isINIGroupMember(sections, hostToAddEncryptedPassword) {
+			lines = append(lines, "address="+hostToAddEncryptedPassword)
+		}
+		lines = append(lines, newPasswordLine)
+	} else if i := slices.IndexFunc(hostSection.entries, func(entry iniEntry) bool { return entry.key == key }); i != -1 {
+		lines[hostSection.entries[i].line-1] = newPasswordLine // Replace password if it exists
+	} else {
+		lines = slices.Insert(lines, hostSection.line, newPasswordLine)
+	}
+
+	// Write the updated content back to the file
+	return writeFileAtomic(filename, []byte(strings.Join(lines, "\n")))
 }
@@ -0,0 +1,166 @@
+package inventory
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// rangePattern matches a numeric or alphabetic range such as [01:40], [a:f] or [1:10:2], and braceList matches a list
+// of alternatives such as {east,west}
+var (
+	rangePattern = regexp.MustCompile(`\[([0-9]+|[a-zA-Z]):([0-9]+|[a-zA-Z])(?::([0-9]+))?\]`)
+	braceList    = regexp.MustCompile(`\{([^{},]*(?:,[^{},]*)+)\}`)
+)
+
+// hasRange reports whether a value contains a range or brace pattern that expandRange would expand
+func hasRange(value string) bool {
+	return rangePattern.MatchString(value) || braceList.MatchString(value)
+}
+
+// expandRange expands every range and brace pattern in value. When a value contains more than one pattern, every
+// combination is returned with the last pattern changing fastest
+func expandRange(value string) ([]string, error) {
+	loc := rangePattern.FindStringSubmatchIndex(value)
+	braceLoc := braceList.FindStringSubmatchIndex(value)
+	if loc == nil || (braceLoc != nil && braceLoc[0] < loc[0]) {
+		loc = braceLoc
+	}
+	if loc == nil {
+		return []string{value}, nil
+	}
+
+	var items []string
+	if value[loc[0]] == '{' {
+		items = strings.Split(value[loc[2]:loc[3]], ",")
+	} else {
+		step := ""
+		if loc[6] != -1 {
+			step = value[loc[6]:loc[7]]
+		}
+		var err error
+		items, err = rangeItems(value[loc[0]:loc[1]], value[loc[2]:loc[3]], value[loc[4]:loc[5]], step)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	suffixes, err := expandRange(value[loc[1]:])
+	if err != nil {
+		return nil, err
+	}
+	expanded := make([]string, 0, len(items)*len(suffixes))
+	for _, item := range items {
+		for _, suffix := range suffixes {
+			expanded = append(expanded, value[:loc[0]]+item+suffix)
+		}
+	}
+	return expanded, nil
+}
+
+// rangeItems lists the values of a single range. Numeric ranges keep the zero padding of their start value
+func rangeItems(pattern, start, end, step string) ([]string, error) {
+	increment := 1
+	if step != "" {
+		var err error
+		increment, err = strconv.Atoi(step)
+		if err != nil || increment < 1 {
+			return nil, fmt.Errorf("invalid step in range %s", pattern)
+		}
+	}
+
+	startNum, startErr := strconv.Atoi(start)
+	endNum, endErr := strconv.Atoi(end)
+	var items []string
+	switch {
+	case startErr == nil && endErr == nil:
+		if startNum > endNum {
+			return nil, fmt.Errorf("range %s ends before it starts", pattern)
+		}
+		for i := startNum; i <= endNum; i += increment {
+			items = append(items, fmt.Sprintf("%0*d", len(start), i))
+		}
+	case startErr != nil && endErr != nil: // Both are single letters
+		if start[0] > end[0] {
+			return nil, fmt.Errorf("range %s ends before it starts", pattern)
+		}
+		for c := int(start[0]); c <= int(end[0]); c += increment {
+			items = append(items, string(rune(c)))
+		}
+	default:
+		return nil, fmt.Errorf("range %s mixes numbers and letters", pattern)
+	}
+	return items, nil
+}
+
+// expandHostRanges turns every host definition whose alias contains a range into one definition per value of the
+// range. An address containing a range must expand to the same number of values, with each host getting the value at
+// the same position as its alias. Ranges in group members are expanded as well
+func expandHostRanges(inv inventoryDefinition) (inventoryDefinition, error) {
+	var hosts []hostDefinition
+	for _, definition := range inv.hosts {
+		if !hasRange(definition.alias) {
+			for _, v := range definition.vars {
+				if v.key == "address" && hasRange(v.value) {
+					return inventoryDefinition{}, fmt.Errorf("the address %s of host %s contains a range but the host's alias does not. "+
+						"Add the same range to the alias, e.g. %s", v.value, definition.alias, definition.alias+"[01:10]")
+				}
+			}
+			hosts = append(hosts, definition)
+			continue
+		}
+
+		aliases, err := expandRange(definition.alias)
+		if err != nil {
+			return inventoryDefinition{}, fmt.Errorf("host %s: %s", definition.alias, err)
+		}
+
+		expandedVars := make(map[string][]string)
+		for _, v := range definition.vars {
+			if v.key != "address" || !hasRange(v.value) {
+				continue
+			}
+			values, err := expandRange(v.value)
+			if err != nil {
+				return inventoryDefinition{}, fmt.Errorf("host %s: %s", definition.alias, err)
+			}
+			if len(values) != len(aliases) {
+				return inventoryDefinition{}, fmt.Errorf("host %s expands to %d hosts but its %s %s expands to %d values. "+
+					"The ranges must be the same length", definition.alias, len(aliases), v.key, v.value, len(values))
+			}
+			expandedVars[v.key] = values
+		}
+
+		for i, alias := range aliases {
+			expanded := definition
+			expanded.alias = alias
+			expanded.vars = nil
+			for _, v := range definition.vars {
+				if values, ok := expandedVars[v.key]; ok {
+					v.value = values[i]
+				}
+				expanded.vars = append(expanded.vars, v)
+			}
+			hosts = append(hosts, expanded)
+		}
+	}
+	inv.hosts = hosts
+
+	groups := make([]groupDefinition, 0, len(inv.groups))
+	for _, group := range inv.groups {
+		var members []string
+		for _, member := range group.members {
+			expanded, err := expandRange(member)
+			if err != nil {
+				return inventoryDefinition{}, fmt.Errorf("group %s: %s", group.name, err)
+			}
+			members = append(members, expanded...)
+		}
+		group.members = members
+		groups = append(groups, group)
+	}
+	inv.groups = groups
+
+	return inv, nil
+}
@@ -0,0 +1,45 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FormatScript marks an inventory as an executable that prints the inventory to stdout. Executable files are detected
+// automatically unless they have the extension of one of the other formats
+const FormatScript = "script"
+
+const inventoryScriptTimeout = time.Second * 30
+
+// isInventoryScript reports whether a file should be run to produce the inventory rather than read
+func isInventoryScript(info os.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0 &&
+		!slices.Contains(inventoryExtensions, strings.ToLower(filepath.Ext(info.Name())))
+}
+
+// runInventoryScript executes a dynamic inventory and returns what it printed. The script is passed --list in the
+// same way Ansible calls its dynamic inventories, so existing Ansible scripts can be used unchanged. The output is
+// JSON in either the layout of an ez-monitor yaml inventory or of an Ansible dynamic inventory
+func runInventoryScript(filename string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), inventoryScriptTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, filename, "--list")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("inventory script %s did not finish within %s", filename, inventoryScriptTimeout)
+		}
+		return nil, fmt.Errorf("inventory script %s failed: %s: %s", filename, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,192 @@
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var (
+	// encryptedValue matches any encrypted password, in either the current or the legacy format
+	encryptedValue = regexp.MustCompile(regexp.QuoteMeta(ezMonitorEncDelimiter) + `(?:` + encryptionVersion + `;[0-9a-fA-F]+;)?[0-9a-fA-F]+`)
+	// legacyEncryptedValue matches passwords encrypted before encrypted values were versioned
+	legacyEncryptedValue = regexp.MustCompile(regexp.QuoteMeta(ezMonitorEncDelimiter) + `[0-9a-fA-F]+`)
+)
+
+// MigrateEncryptedPasswords re-encrypts every password in the file that uses the legacy encryption format with the
+// current one, reading the encryption password from vault or prompting for it. The file is edited in place so its
+// formatting and comments are kept, and it is left untouched if any password cannot be decrypted. Returns how many
+// passwords were migrated
+func MigrateEncryptedPasswords(filename string, vault VaultPasswordOptions) (int, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read file: %s", err)
+	}
+	if isEncryptedInventory(content) {
+		encPassword, err := encryptionPassword(vault)
+		if err != nil {
+			return 0, err
+		}
+		return reencryptInventory(filename, content, legacyEncryptedValue, encPassword, encPassword)
+	}
+	if !legacyEncryptedValue.Match(content) {
+		return 0, nil
+	}
+
+	encPassword, err := encryptionPassword(vault)
+	if err != nil {
+		return 0, err
+	}
+	updated, migrated, err := reencryptPasswords(filename, content, legacyEncryptedValue, encPassword, encPassword)
+	if err != nil {
+		return 0, err
+	}
+	return migrated, writeFileAtomic(filename, updated)
+}
+
+// RekeyEncryptedPasswords re-encrypts every password in the file with a new encryption password that is prompted for.
+// The current encryption password is read from vault, or prompted for if vault has no source for it. As with
+// MigrateEncryptedPasswords, the file is edited in place and left untouched if any password cannot be decrypted. An
+// encrypted inventory is itself re-encrypted along with any passwords in it. Files with Ansible Vault values are
+// refused. Returns how many passwords were re-encrypted
+func RekeyEncryptedPasswords(filename string, vault VaultPasswordOptions) (int, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read file: %s", err)
+	}
+	encrypted := isEncryptedInventory(content)
+	if !encrypted {
+		if err := checkNoAnsibleVaultValues(filename, content); err != nil {
+			return 0, err
+		}
+		if !encryptedValue.Match(content) {
+			return 0, nil
+		}
+	}
+
+	oldPassword, err := encryptionPassword(vault)
+	if err != nil {
+		return 0, err
+	}
+	newPassword, err := promptNewEncryptionPassword()
+	if err != nil {
+		return 0, err
+	}
+	if encrypted {
+		return reencryptInventory(filename, content, encryptedValue, oldPassword, newPassword)
+	}
+	updated, rekeyed, err := reencryptPasswords(filename, content, encryptedValue, oldPassword, newPassword)
+	if err != nil {
+		return 0, err
+	}
+	return rekeyed, writeFileAtomic(filename, updated)
+}
+
+// reencryptInventory decrypts an encrypted inventory with oldPassword, re-encrypts the passwords in it that match
+// pattern, then encrypts the inventory again with newPassword
+func reencryptInventory(filename string, content []byte, pattern *regexp.Regexp, oldPassword, newPassword string) (int, error) {
+	plaintext, err := decryptInventory(content, oldPassword)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", filename, err)
+	}
+	if oldPassword != newPassword {
+		if err := checkNoAnsibleVaultValues(filename, plaintext); err != nil {
+			return 0, err
+		}
+	}
+	updated, count, err := reencryptPasswords(filename, plaintext, pattern, oldPassword, newPassword)
+	if err != nil {
+		return 0, err
+	}
+	encrypted, err := encryptInventory(updated, newPassword)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt inventory: %s", err)
+	}
+	return count, writeFileAtomic(filename, encrypted)
+}
+
+// reencryptPasswords decrypts every value matching pattern with oldPassword and replaces it with the value encrypted
+// with newPassword. Every value is decrypted before the updated content is returned so that the file is never written
+// with passwords encrypted by a mix of encryption passwords
+func reencryptPasswords(filename string, content []byte, pattern *regexp.Regexp, oldPassword, newPassword string) ([]byte, int, error) {
+	var updated bytes.Buffer
+	last := 0
+	matches := pattern.FindAllIndex(content, -1)
+	for _, match := range matches {
+		password, err := decrypt(string(content[match[0]:match[1]]), oldPassword)
+		if err != nil {
+			line := bytes.Count(content[:match[0]], []byte("\n")) + 1
+			return nil, 0, fmt.Errorf("%s:%d: failed to decrypt password. Was it encrypted with a different encryption password? %s", filename, line, err)
+		}
+		reencrypted, err := encrypt(password, newPassword)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to encrypt password: %s", err)
+		}
+		updated.Write(content[last:match[0]])
+		updated.WriteString(reencrypted)
+		last = match[1]
+	}
+	updated.Write(content[last:])
+	return updated.Bytes(), len(matches), nil
+}
+
+// checkNoAnsibleVaultValues refuses to change the encryption password of a file with Ansible Vault values. They are
+// decrypted with the encryption password but cannot be re-encrypted in place, so they would no longer decrypt
+func checkNoAnsibleVaultValues(filename string, content []byte) error {
+	i := bytes.Index(content, []byte(ansibleVaultHeader))
+	if i == -1 {
+		return nil
+	}
+	line := bytes.Count(content[:i], []byte("\n")) + 1
+	return fmt.Errorf("%s:%d: Ansible Vault values cannot be re-encrypted with a new encryption password and would no "+
+		"longer decrypt, so the file was left unchanged. Remove them before changing the encryption password, then "+
+		"encrypt them again with ansible-vault encrypt_string using the new encryption password", filename, line)
+}
+
+// writeFileAtomic replaces the contents of an existing file, keeping its permissions. The data is written to a
+// temporary file in the same directory which is then renamed over the original, so readers see either the old or the
+// new contents and never a partially written file
+func writeFileAtomic(filename string, data []byte) error {
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %s", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once the file has been renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write updated file: %s", err)
+	}
+	if err := tmp.Chmod(stat.Mode()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions of updated file: %s", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write updated file: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write updated file: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("failed to replace file: %s", err)
+	}
+	return nil
+}
+
+func readPassword(prompt string) (string, error) {
+	fmt.Println(prompt)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println() // Print a newline after password input
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %s", err)
+	}
+	return string(password), nil
+}
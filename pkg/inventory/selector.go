@@ -0,0 +1,208 @@
+package inventory
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Selector picks which hosts of an inventory to monitor, so that one inventory can be used for many smaller
+// monitoring sessions
+type Selector struct {
+	limit []string // Glob patterns matched against a host's alias and groups, where a leading ! excludes
+	tags  tagExpr  // nil when hosts are not filtered by their tags
+}
+
+// NewSelector parses a --limit pattern list and a --tags expression. Either may be empty to not filter on it.
+//
+// The limit is a comma separated list of patterns where * matches any characters and ? matches a single character. A
+// host is selected if its alias or one of its groups matches any pattern, unless it matches a pattern starting with !.
+// The tags expression combines tag names with and, or, not and parentheses, e.g. "prod and (web or db) and not canary"
+func NewSelector(limit, tags string) (*Selector, error) {
+	s := &Selector{}
+	for _, pattern := range strings.Split(limit, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			s.limit = append(s.limit, pattern)
+		}
+	}
+	if strings.TrimSpace(tags) != "" {
+		var err error
+		s.tags, err = parseTagExpr(tags)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tags expression %q: %s", tags, err)
+		}
+	}
+	return s, nil
+}
+
+// Select returns the hosts that match both the limit and the tags expression, in their original order
+func (s *Selector) Select(hosts []Host) []Host {
+	var selected []Host
+	for _, host := range hosts {
+		if s.matchesLimit(host) && (s.tags == nil || s.tags.eval(host.Tags)) {
+			selected = append(selected, host)
+		}
+	}
+	return selected
+}
+
+func (s *Selector) matchesLimit(host Host) bool {
+	names := append([]string{host.Alias}, host.Groups...)
+	matches := func(pattern string) bool {
+		return slices.ContainsFunc(names, func(name string) bool { return matchWildcard(pattern, name) })
+	}
+
+	hasInclude, included := false, false
+	for _, pattern := range s.limit {
+		if excluded, ok := strings.CutPrefix(pattern, "!"); ok {
+			if matches(excluded) {
+				return false
+			}
+			continue
+		}
+		hasInclude = true
+		included = included || matches(pattern)
+	}
+	return included || !hasInclude // Only exclusions means every other host is selected
+}
+
+// tagExpr is a parsed boolean expression over a host's tags
+type tagExpr interface {
+	eval(tags []string) bool
+}
+
+type tagName string
+
+func (t tagName) eval(tags []string) bool { return slices.Contains(tags, string(t)) }
+
+type tagNot struct{ expr tagExpr }
+
+func (t tagNot) eval(tags []string) bool { return !t.expr.eval(tags) }
+
+type tagAnd struct{ left, right tagExpr }
+
+func (t tagAnd) eval(tags []string) bool { return t.left.eval(tags) && t.right.eval(tags) }
+
+type tagOr struct{ left, right tagExpr }
+
+func (t tagOr) eval(tags []string) bool { return t.left.eval(tags) || t.right.eval(tags) }
+
+// tagParser is a recursive descent parser for tag expressions where not binds tighter than and, which binds tighter
+// than or. The symbols !, && and || may be used in place of the words
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseTagExpr(expr string) (tagExpr, error) {
+	p := &tagParser{tokens: tokenizeTagExpr(expr)}
+	parsed, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return parsed, nil
+}
+
+// tokenizeTagExpr splits an expression into parentheses, operators and tag names
+func tokenizeTagExpr(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(' || c == ')' || c == '!':
+			tokens = append(tokens, string(c))
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		default:
+			end := i
+			for end < len(expr) && !unicode.IsSpace(rune(expr[end])) && !strings.ContainsRune("()!&|", rune(expr[end])) {
+				end++
+			}
+			if end == i { // A lone & or |
+				end++
+			}
+			tokens = append(tokens, expr[i:end])
+			i = end
+		}
+	}
+	return tokens
+}
+
+func (p *tagParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tagParser) parseOr() (tagExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for token := p.peek(); token == "or" || token == "||"; token = p.peek() {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = tagOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *tagParser) parseAnd() (tagExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for token := p.peek(); token == "and" || token == "&&"; token = p.peek() {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = tagAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *tagParser) parseNot() (tagExpr, error) {
+	switch token := p.peek(); token {
+	case "not", "!":
+		p.pos++
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{expr}, nil
+	case "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	case "":
+		return nil, fmt.Errorf("expected a tag name at the end of the expression")
+	case ")", "and", "or", "&&", "||":
+		return nil, fmt.Errorf("expected a tag name but found %s", token)
+	default:
+		if strings.ContainsAny(token, "&|") {
+			return nil, fmt.Errorf("unexpected %s", token)
+		}
+		p.pos++
+		return tagName(token), nil
+	}
+}
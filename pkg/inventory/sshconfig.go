@@ -0,0 +1,385 @@
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/mitchellh/go-homedir"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const DefaultSshConfigFile = "~/.ssh/config"
+
+// maxSshConfigDepth guards against Include loops and ProxyJump chains that reference each other
+const maxSshConfigDepth = 16
+
+// sshConfig is an OpenSSH client configuration file. Only the options that map onto a Host are interpreted, everything
+// else is ignored just as ssh would ignore options it has no use for
+type sshConfig struct {
+	blocks []sshConfigBlock
+}
+
+// sshConfigBlock is a set of options that apply to any host matching its patterns. A nil patterns slice matches every
+// host, which is the case for options defined before the first Host line
+type sshConfigBlock struct {
+	patterns []string
+	options  []sshConfigOption
+}
+
+type sshConfigOption struct {
+	key   string // Keywords are case-insensitive so they are stored in lower case
+	value string
+}
+
+// LoadSshConfig creates a Host for every concrete alias defined on a Host line of an OpenSSH client config file.
+// Wildcard patterns such as Host * are not hosts themselves but their options are applied to the hosts they match
+func LoadSshConfig(filename string) ([]Host, error) {
+	cfg, err := loadSshConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []Host
+	seenAliases := make(map[string]bool)
+	for _, block := range cfg.blocks {
+		for _, pattern := range block.patterns {
+			if seenAliases[pattern] || strings.ContainsAny(pattern, "*?!") {
+				continue
+			}
+			seenAliases[pattern] = true
+
+			host, err := cfg.resolveHost(pattern, 0)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+func loadSshConfig(filename string) (*sshConfig, error) {
+	cfg := &sshConfig{}
+	if err := cfg.read(filename, nil, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// read appends the blocks of a config file. Options before the first Host line of an included file belong to
+// the block the Include was in, and that block continues once the included file has been read
+func (c *sshConfig) read(filename string, patterns []string, depth int) error {
+	if depth > maxSshConfigDepth {
+		return fmt.Errorf("ssh config %s: too many nested Include directives", filename)
+	}
+
+	path, err := homedir.Expand(filename)
+	if err != nil {
+		return fmt.Errorf("failed to expand ssh config file %s: %s", filename, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read ssh config file: %s", err)
+	}
+
+	c.blocks = append(c.blocks, sshConfigBlock{patterns: patterns})
+	current := &c.blocks[len(c.blocks)-1]
+	skipping := false // Set while inside a Match block as their criteria are not evaluated
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		key, args := splitSshConfigLine(scanner.Text())
+		if key == "" {
+			continue
+		}
+
+		switch key {
+		case "host":
+			if len(args) == 0 {
+				return fmt.Errorf("ssh config %s line %d: Host requires at least one pattern", path, lineNumber)
+			}
+			c.blocks = append(c.blocks, sshConfigBlock{patterns: args})
+			current = &c.blocks[len(c.blocks)-1]
+			skipping = false
+		case "match":
+			skipping = !(len(args) == 1 && strings.ToLower(args[0]) == "all")
+			c.blocks = append(c.blocks, sshConfigBlock{})
+			current = &c.blocks[len(c.blocks)-1]
+		case "include":
+			if skipping {
+				continue
+			}
+			includePatterns := current.patterns
+			for _, arg := range args {
+				matches, err := expandSshConfigInclude(arg)
+				if err != nil {
+					return fmt.Errorf("ssh config %s line %d: %s", path, lineNumber, err)
+				}
+				for _, match := range matches {
+					if err := c.read(match, includePatterns, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			c.blocks = append(c.blocks, sshConfigBlock{patterns: includePatterns})
+			current = &c.blocks[len(c.blocks)-1]
+		default:
+			if skipping || len(args) == 0 {
+				continue
+			}
+			current.options = append(current.options, sshConfigOption{key: key, value: strings.Join(args, " ")})
+		}
+	}
+	return scanner.Err()
+}
+
+// splitSshConfigLine returns the lower cased keyword of a config line along with its arguments. Keywords may be
+// separated from their arguments by whitespace or an =, and arguments may be wrapped in double quotes
+func splitSshConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end == -1 {
+		return strings.ToLower(line), nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(strings.TrimSpace(line[end:]), "=")
+
+	var args []string
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing == -1 {
+				args = append(args, rest[1:])
+				break
+			}
+			args = append(args, rest[1:closing+1])
+			rest = rest[closing+2:]
+			continue
+		}
+		next := strings.IndexAny(rest, " \t")
+		if next == -1 {
+			args = append(args, rest)
+			break
+		}
+		args = append(args, rest[:next])
+		rest = rest[next:]
+	}
+	return key, args
+}
+
+// expandSshConfigInclude resolves an Include argument to the files it refers to. Relative paths are relative to ~/.ssh
+// in the same way ssh treats them for the user's config
+func expandSshConfigInclude(pattern string) ([]string, error) {
+	pattern, err := homedir.Expand(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(pattern) {
+		sshDir, err := homedir.Expand("~/.ssh")
+		if err != nil {
+			return nil, err
+		}
+		pattern = filepath.Join(sshDir, pattern)
+	}
+	return filepath.Glob(pattern)
+}
+
+// options returns the value of every option that applies to name. As with ssh, the first value found for an option is
+// the one used, except for IdentityFile which may be given multiple times
+func (c *sshConfig) options(name string) map[string][]string {
+	options := make(map[string][]string)
+	for _, block := range c.blocks {
+		if block.patterns != nil && !matchSshConfigPatterns(block.patterns, name) {
+			continue
+		}
+		for _, option := range block.options {
+			if _, ok := options[option.key]; ok && option.key != "identityfile" {
+				continue
+			}
+			options[option.key] = append(options[option.key], option.value)
+		}
+	}
+	return options
+}
+
+// resolveHost builds a Host from the options that apply to name, following any ProxyJump hosts through the config
+func (c *sshConfig) resolveHost(name string, depth int) (Host, error) {
+	if depth > maxSshConfigDepth {
+		return Host{}, fmt.Errorf("ssh config host %s: ProxyJump chain is too long or contains a loop", name)
+	}
+
+	options := c.options(name)
+	host := Host{
+		Alias:   name,
+		Address: name,
+	}
+	if hostname, ok := options["hostname"]; ok {
+		host.Address = strings.ReplaceAll(hostname[0], "%h", name)
+	}
+	if username, ok := options["user"]; ok {
+		host.Username = username[0]
+	} else if currentUser, err := user.Current(); err == nil { // ssh falls back to the local username
+		host.Username = currentUser.Username
+	}
+	if port, ok := options["port"]; ok {
+		var err error
+		host.Port, err = strconv.Atoi(port[0])
+		if err != nil {
+			return Host{}, fmt.Errorf("invalid Port for ssh config host %s: %s", name, err)
+		}
+	}
+	if identityFiles, ok := options["identityfile"]; ok {
+		host.SshPrivateKeyFile = expandSshConfigTokens(identityFiles[0], host)
+	}
+	if certificateFiles, ok := options["certificatefile"]; ok {
+		host.SshCertificateFile = expandSshConfigTokens(certificateFiles[0], host)
+	}
+	if identityAgent, ok := options["identityagent"]; ok {
+		host.IdentityAgent, host.DisableSshAgent = parseIdentityAgent(expandSshConfigTokens(identityAgent[0], host))
+	}
+	if knownHostsFiles, ok := options["userknownhostsfile"]; ok {
+		host.KnownHostsFile = expandSshConfigTokens(knownHostsFiles[0], host)
+	}
+	if proxyJump, ok := options["proxyjump"]; ok && strings.ToLower(proxyJump[0]) != "none" {
+		jumpHost, err := c.resolveJumpHosts(strings.Split(proxyJump[0], ","), depth+1)
+		if err != nil {
+			return Host{}, err
+		}
+		host.JumpHost = jumpHost
+	}
+	return host, nil
+}
+
+// resolveJumpHosts turns a ProxyJump list of [user@]host[:port] entries into a chain of Hosts where the last entry is
+// the one connected to directly by the target host and every entry is reached through the one before it
+func (c *sshConfig) resolveJumpHosts(specs []string, depth int) (*Host, error) {
+	var previous *Host
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		username, address, port, err := parseJumpHostSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		jumpHost, err := c.resolveHost(address, depth)
+		if err != nil {
+			return nil, err
+		}
+		jumpHost.Alias = spec
+		if username != "" {
+			jumpHost.Username = username
+		}
+		if port != 0 {
+			jumpHost.Port = port
+		}
+		if previous != nil { // Hosts later in the list are reached through the earlier ones
+			jumpHost.JumpHost = previous
+		}
+		previous = &jumpHost
+	}
+	return previous, nil
+}
+
+// parseIdentityAgent interprets an agent socket the same way as ssh's IdentityAgent option, where none disables the
+// agent and SSH_AUTH_SOCK uses the socket from the environment
+func parseIdentityAgent(value string) (socket string, disabled bool) {
+	switch value {
+	case "none":
+		return "", true
+	case "SSH_AUTH_SOCK", "$SSH_AUTH_SOCK":
+		return "", false
+	}
+	return value, false
+}
+
+// parseJumpHostSpec splits a [user@]host[:port] jump host specification into its parts
+func parseJumpHostSpec(spec string) (username, address string, port int, err error) {
+	address = spec
+	if at := strings.LastIndex(address, "@"); at != -1 {
+		username, address = address[:at], address[at+1:]
+	}
+	if colon := strings.LastIndex(address, ":"); colon != -1 && !strings.Contains(address[colon+1:], "]") {
+		port, err = strconv.Atoi(address[colon+1:])
+		if err != nil {
+			return "", "", 0, fmt.Errorf("invalid port in jump host %s: %s", spec, err)
+		}
+		address = address[:colon]
+	}
+	address = strings.Trim(address, "[]")
+	if address == "" {
+		return "", "", 0, fmt.Errorf("invalid jump host %s: missing host", spec)
+	}
+	return username, address, port, nil
+}
+
+// expandSshConfigTokens replaces the % tokens ssh supports in IdentityFile paths that can be derived from a Host
+func expandSshConfigTokens(value string, host Host) string {
+	replacements := []string{"%%", "%", "%h", host.Address, "%r", host.Username, "%p", strconv.Itoa(sshPort(host)), "%n", host.Alias}
+	if home, err := homedir.Dir(); err == nil {
+		replacements = append(replacements, "%d", home)
+	}
+	if currentUser, err := user.Current(); err == nil {
+		replacements = append(replacements, "%u", currentUser.Username)
+	}
+	return strings.NewReplacer(replacements...).Replace(value)
+}
+
+func sshPort(host Host) int {
+	if host.Port != 0 {
+		return host.Port
+	}
+	return 22
+}
+
+// matchSshConfigPatterns reports whether name matches a Host line. Any negated pattern that matches excludes the host
+// even if other patterns match it
+func matchSshConfigPatterns(patterns []string, name string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if matchWildcard(negated, name) {
+				return false
+			}
+			continue
+		}
+		if matchWildcard(pattern, name) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchWildcard matches name against a pattern where * matches any number of characters and ? matches exactly one
+func matchWildcard(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(name); i >= 0; i-- {
+				if matchWildcard(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+		default:
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
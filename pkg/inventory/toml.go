@@ -0,0 +1,145 @@
+package inventory
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"slices"
+	"strings"
+)
+
+// parseTOML reads an inventory of the form
+//
+//	[vars]
+//	username = "some-user"
+//
+//	[hosts.host-1]
+//	address = "ubuntu-server-1"
+//
+//	[groups.web]
+//	hosts = ["host-1"]
+//	[groups.web.vars]
+//	port = 2222
+//
+// where every table under hosts is a host entry named after its alias, and vars apply to every host. Other inventory
+// files can be loaded with a top level include = ["other-inventory.ini"]
+func parseTOML(data []byte) (inventoryDefinition, error) {
+	var document map[string]any
+	md, err := toml.Decode(string(data), &document)
+	if err != nil {
+		return inventoryDefinition{}, fmt.Errorf("failed to load toml data: %s", err)
+	}
+
+	var inv inventoryDefinition
+	for key, value := range document {
+		if key == "include" {
+			includes, err := tomlScalarValue(value)
+			if err != nil {
+				return inventoryDefinition{}, fmt.Errorf("include: %s", err)
+			}
+			inv.includes = splitList(includes)
+			continue
+		}
+		if key != "hosts" && key != "groups" && key != "vars" {
+			return inventoryDefinition{}, fmt.Errorf("unknown top level key %s", key)
+		}
+		if _, ok := value.(map[string]any); !ok {
+			return inventoryDefinition{}, fmt.Errorf("%s must be a table", key)
+		}
+	}
+
+	// The decoded maps do not retain the order of the file so we use the metadata to keep entries in the order they were defined
+	inv.vars, err = tomlTableVars(md, document, "vars")
+	if err != nil {
+		return inventoryDefinition{}, err
+	}
+	for _, alias := range tomlChildKeys(md, "hosts") {
+		vars, err := tomlTableVars(md, document, "hosts", alias)
+		if err != nil {
+			return inventoryDefinition{}, fmt.Errorf("host %s: %s", alias, err)
+		}
+		inv.hosts = append(inv.hosts, hostDefinition{alias: alias, vars: vars})
+	}
+	for _, name := range tomlChildKeys(md, "groups") {
+		group := groupDefinition{name: name}
+		for _, key := range tomlChildKeys(md, "groups", name) {
+			var err error
+			switch key {
+			case "hosts":
+				var members string
+				members, err = tomlScalarValue(tomlLookup(document, "groups", name, "hosts"))
+				group.members = splitList(members)
+			case "vars":
+				group.vars, err = tomlTableVars(md, document, "groups", name, "vars")
+			default:
+				err = fmt.Errorf("unknown key %s", key)
+			}
+			if err != nil {
+				return inventoryDefinition{}, fmt.Errorf("group %s: %s", name, err)
+			}
+		}
+		inv.groups = append(inv.groups, group)
+	}
+	return inv, nil
+}
+
+// tomlChildKeys returns the names of the keys directly beneath the given table path in the order they were defined
+func tomlChildKeys(md toml.MetaData, path ...string) []string {
+	var children []string
+	for _, key := range md.Keys() {
+		if len(key) == len(path)+1 && slices.Equal(key[:len(path)], path) {
+			children = append(children, key[len(path)])
+		}
+	}
+	return children
+}
+
+func tomlLookup(document map[string]any, path ...string) any {
+	var value any = document
+	for _, key := range path {
+		table, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = table[key]
+	}
+	return value
+}
+
+func tomlTableVars(md toml.MetaData, document map[string]any, path ...string) ([]variable, error) {
+	table := tomlLookup(document, path...)
+	if table == nil {
+		return nil, nil
+	}
+	if _, ok := table.(map[string]any); !ok {
+		return nil, fmt.Errorf("%s must be a table", strings.Join(path, "."))
+	}
+
+	var vars []variable
+	for _, key := range tomlChildKeys(md, path...) {
+		value, err := tomlScalarValue(tomlLookup(document, slices.Concat(path, []string{key})...))
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %s", key, err)
+		}
+		vars = append(vars, variable{key: key, value: value})
+	}
+	return vars, nil
+}
+
+func tomlScalarValue(value any) (string, error) {
+	switch v := value.(type) {
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case []any, map[string]any:
+				return "", fmt.Errorf("arrays may only contain plain values")
+			}
+			values = append(values, fmt.Sprint(item))
+		}
+		return strings.Join(values, ","), nil
+	case map[string]any:
+		return "", fmt.Errorf("nested tables are not supported as variable values")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
@@ -0,0 +1,74 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/go-homedir"
+	"os"
+)
+
+// Diagnostic is a problem found in an inventory along with where it was found. File and Line are only set when the
+// problem can be traced back to a specific place, and Line is zero for formats that do not provide line numbers
+type Diagnostic struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func newDiagnostic(file string, line int, format string, args ...any) Diagnostic {
+	return Diagnostic{File: file, Line: line, Message: fmt.Sprintf(format, args...)}
+}
+
+func (d Diagnostic) Error() string {
+	switch {
+	case d.File == "":
+		return d.Message
+	case d.Line == 0:
+		return fmt.Sprintf("%s: %s", d.File, d.Message)
+	default:
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	}
+}
+
+// ValidateInventory loads the inventory and returns every problem found in it rather than stopping at the first. On
+// top of the checks made by LoadInventory, files that the inventory references are checked to be readable
+func ValidateInventory(filename string, opts LoadOptions) []Diagnostic {
+	reader := &inventoryReader{visited: make(map[string]bool), vault: opts.VaultPassword}
+	inv, err := reader.readPath(filename, opts.Format)
+	if err == nil {
+		_, err = buildHosts(inv, &hostBuilder{opts: opts, checkFiles: true})
+	}
+	return diagnostics(err)
+}
+
+// diagnostics flattens an error returned while loading an inventory into its individual problems
+func diagnostics(err error) []Diagnostic {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var all []Diagnostic
+		for _, e := range joined.Unwrap() {
+			all = append(all, diagnostics(e)...)
+		}
+		return all
+	}
+	var diagnostic Diagnostic
+	if errors.As(err, &diagnostic) {
+		return []Diagnostic{diagnostic}
+	}
+	return []Diagnostic{{Message: err.Error()}}
+}
+
+// checkReadable reports why a file referenced by the inventory cannot be read, if it cannot be
+func checkReadable(filename string) error {
+	path, err := homedir.Expand(filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,211 @@
+package inventory
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// An encrypted inventory file hides everything in the inventory, not only its passwords. The whole file is encrypted
+// the same way as a password and stored as
+//
+//	$EZ_MONITOR_VAULT;v2;<hex salt>
+//	<hex nonce and ciphertext, wrapped at vaultLineLength characters>
+//
+// Encrypted inventories are decrypted in memory when they are loaded and never written to disk in plain text, other
+// than to the private temporary copy made by EditEncryptedInventory
+const (
+	vaultHeader     = "$EZ_MONITOR_VAULT;"
+	vaultLineLength = 80
+)
+
+// isEncryptedInventory reports whether the contents of an inventory file are encrypted
+func isEncryptedInventory(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(vaultHeader))
+}
+
+// readInventoryFile reads an inventory file, decrypting it if it is encrypted
+func readInventoryFile(filename string, vault VaultPasswordOptions) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil || !isEncryptedInventory(data) {
+		return data, err
+	}
+	encPassword, err := encryptionPassword(vault)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decryptInventory(data, encPassword)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return plaintext, nil
+}
+
+func encryptInventory(plaintext []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(key, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	var encrypted bytes.Buffer
+	fmt.Fprintf(&encrypted, "%s%s;%s\n", vaultHeader, encryptionVersion, hex.EncodeToString(salt))
+	encoded := hex.EncodeToString(ciphertext)
+	for len(encoded) > 0 {
+		line := encoded[:min(vaultLineLength, len(encoded))]
+		encoded = encoded[len(line):]
+		encrypted.WriteString(line + "\n")
+	}
+	return encrypted.Bytes(), nil
+}
+
+func decryptInventory(data []byte, passphrase string) ([]byte, error) {
+	header, body, _ := strings.Cut(string(data), "\n")
+	versioned := strings.TrimPrefix(strings.TrimSpace(header), vaultHeader)
+	version, encodedSalt, found := strings.Cut(versioned, ";")
+	if !found {
+		return nil, errors.New("failed to parse encrypted inventory: missing salt")
+	}
+	if version != encryptionVersion {
+		return nil, fmt.Errorf("unsupported encrypted inventory version %s", version)
+	}
+	salt, err := hex.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted inventory: %s", err)
+	}
+	ciphertext, err := hex.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted inventory: %s", err)
+	}
+
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(key, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt inventory. Was it encrypted with a different encryption password? %s", err)
+	}
+	return plaintext, nil
+}
+
+// EncryptInventory encrypts an entire inventory file in place. The encryption password is read from vault, or prompted
+// for twice if vault has no source for it
+func EncryptInventory(filename string, vault VaultPasswordOptions) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %s", err)
+	}
+	if isEncryptedInventory(data) {
+		return fmt.Errorf("%s is already encrypted", filename)
+	}
+
+	encPassword, err := newEncryptionPassword(vault)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encryptInventory(data, encPassword)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt inventory: %s", err)
+	}
+	return writeFileAtomic(filename, encrypted)
+}
+
+// DecryptInventory replaces an encrypted inventory file with its plain text contents
+func DecryptInventory(filename string, vault VaultPasswordOptions) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %s", err)
+	}
+	if !isEncryptedInventory(data) {
+		return fmt.Errorf("%s is not encrypted", filename)
+	}
+
+	plaintext, err := readInventoryFile(filename, vault)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, plaintext)
+}
+
+// EditEncryptedInventory decrypts an encrypted inventory file to a temporary file that only the current user can read,
+// opens it in $VISUAL or $EDITOR, and encrypts the edited contents back into the original file with the same encryption
+// password. The temporary file is removed once the editor exits
+func EditEncryptedInventory(filename string, vault VaultPasswordOptions) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %s", err)
+	}
+	if !isEncryptedInventory(data) {
+		return fmt.Errorf("%s is not encrypted", filename)
+	}
+	encPassword, err := encryptionPassword(vault)
+	if err != nil {
+		return err
+	}
+	plaintext, err := decryptInventory(data, encPassword)
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "ez-monitor-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	// Keep the file name so that the editor recognises the inventory's format
+	tmpFile := filepath.Join(tmpDir, filepath.Base(filename))
+	if err := os.WriteFile(tmpFile, plaintext, 0600); err != nil {
+		return fmt.Errorf("failed to write temporary file: %s", err)
+	}
+
+	if err := runEditor(tmpFile); err != nil {
+		return err
+	}
+	edited, err := os.ReadFile(tmpFile)
+	if err != nil {
+		return fmt.Errorf("failed to read edited file: %s", err)
+	}
+	if bytes.Equal(edited, plaintext) {
+		return nil
+	}
+
+	encrypted, err := encryptInventory(edited, encPassword)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt inventory: %s", err)
+	}
+	return writeFileAtomic(filename, encrypted)
+}
+
+// runEditor opens a file in the user's editor and waits for it to exit. The editor is run through the shell as
+// $EDITOR commonly includes arguments, such as code --wait
+func runEditor(filename string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", filename)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %s", editor, err)
+	}
+	return nil
+}
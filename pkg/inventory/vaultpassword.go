@@ -0,0 +1,127 @@
+package inventory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// VaultPasswordEnv is the environment variable the encryption password is read from if neither a file nor a command
+// is given
+const VaultPasswordEnv = "EZ_MONITOR_VAULT_PASSWORD"
+
+// VaultPasswordOptions are the ways the encryption password can be provided without prompting for it, so that
+// ez-monitor can run without a terminal such as under systemd, cron or in a container. Only one of File and Command
+// may be set. They take precedence over VaultPasswordEnv, and the user is only prompted if none of them are set
+type VaultPasswordOptions struct {
+	File    string // Path of a file containing the encryption password
+	Command string // Shell command that prints the encryption password, such as pass show ez-monitor
+	// NoPrompt fails instead of prompting for the encryption password, and gives Command no stdin. It is set for reloads
+	// of the inventory, which happen once the TUI has taken over the terminal so that a prompt would never be seen and
+	// its input would be read as key presses
+	NoPrompt bool
+}
+
+// If we see an encrypted password we will get this value from its source and then save it for further passwords. It
+// is kept for the life of the process so that refreshing the inventory does not prompt again
+var (
+	encPassword   string
+	encPasswordMu sync.Mutex
+)
+
+// encryptionPassword returns the password used to decrypt the host passwords, reading it from its source the first time
+func encryptionPassword(opts VaultPasswordOptions) (string, error) {
+	encPasswordMu.Lock()
+	defer encPasswordMu.Unlock()
+	if encPassword != "" {
+		return encPassword, nil
+	}
+
+	password, err := readVaultPassword(opts)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", errors.New("the encryption password is empty")
+	}
+	encPassword = password
+	return encPassword, nil
+}
+
+// newEncryptionPassword returns the password to encrypt with. If opts or VaultPasswordEnv provide one it is used, as
+// it is what will be read when decrypting, otherwise the user is prompted for a new password twice to confirm it
+func newEncryptionPassword(opts VaultPasswordOptions) (string, error) {
+	if _, ok := os.LookupEnv(VaultPasswordEnv); opts.File != "" || opts.Command != "" || ok {
+		return encryptionPassword(opts)
+	}
+	return promptNewEncryptionPassword()
+}
+
+// promptNewEncryptionPassword prompts for a new encryption password, which must be entered twice
+func promptNewEncryptionPassword() (string, error) {
+	newPassword, err := readPassword("Enter the new encryption password:")
+	if err != nil {
+		return "", err
+	}
+	confirmedPassword, err := readPassword("Enter the new encryption password again to confirm it:")
+	if err != nil {
+		return "", err
+	}
+	if newPassword != confirmedPassword {
+		return "", fmt.Errorf("the new encryption passwords do not match")
+	}
+	if newPassword == "" {
+		return "", fmt.Errorf("the new encryption password cannot be empty")
+	}
+	return newPassword, nil
+}
+
+func readVaultPassword(opts VaultPasswordOptions) (string, error) {
+	switch {
+	case opts.File != "" && opts.Command != "":
+		return "", errors.New("only one of a vault password file and a vault password command may be given")
+	case opts.File != "":
+		data, err := os.ReadFile(opts.File)
+		if err != nil {
+			return "", fmt.Errorf("failed to read vault password file: %s", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case opts.Command != "":
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", opts.Command)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if !opts.NoPrompt {
+			cmd.Stdin = os.Stdin // The command may prompt, e.g. to unlock a gpg key
+		}
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("vault password command %q failed: %s: %s", opts.Command, err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(stdout.String(), "\r\n"), nil
+	}
+
+	if password, ok := os.LookupEnv(VaultPasswordEnv); ok {
+		return password, nil
+	}
+
+	if opts.NoPrompt {
+		return "", fmt.Errorf("an encryption password is needed to decrypt the host passwords but it cannot be prompted for "+
+			"while monitoring. Provide it with --vault-password-file, --vault-password-command or the %s environment variable", VaultPasswordEnv)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("an encryption password is needed to decrypt the host passwords but there is no terminal to prompt for it. "+
+			"Provide it with --vault-password-file, --vault-password-command or the %s environment variable", VaultPasswordEnv)
+	}
+	fmt.Println("Please enter your encryption password to decrypt the host passwords in this file.")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println() // Print a newline after password input
+	if err != nil {
+		return "", fmt.Errorf("failed to read encryption password: %s", err)
+	}
+	return string(password), nil
+}
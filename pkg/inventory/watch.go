@@ -0,0 +1,77 @@
+package inventory
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// Reload is the result of reloading an inventory, holding either the reloaded hosts or why the inventory failed to load
+type Reload struct {
+	Hosts []Host
+	Err   error
+}
+
+// Watch reloads the inventory with load whenever one of the files it was read from is modified, checking every
+// pollInterval, and also every refresh unless it is zero. paths are the files and directories of the initial load, and
+// load returns those of each reload so that files included later on are watched too. The result of every reload is sent
+// on the returned channel, which is closed once ctx is done. Failed loads are sent with their error and no hosts so that
+// a temporarily broken inventory, such as a script whose backing service is down, can be reported without stopping the
+// hosts already being monitored
+func Watch(ctx context.Context, paths []string, pollInterval, refresh time.Duration, load func() ([]Host, []string, error)) <-chan Reload {
+	reloadChan := make(chan Reload)
+	go func() {
+		defer close(reloadChan)
+		modTimes := pathModTimes(paths)
+
+		pollTicker := time.NewTicker(pollInterval)
+		defer pollTicker.Stop()
+		var refreshChan <-chan time.Time // Never receives if the inventory is only reloaded when it is modified
+		if refresh > 0 {
+			refreshTicker := time.NewTicker(refresh)
+			defer refreshTicker.Stop()
+			refreshChan = refreshTicker.C
+		}
+		for {
+			select {
+			case <-pollTicker.C:
+				if maps.Equal(modTimes, pathModTimes(slices.Collect(maps.Keys(modTimes)))) {
+					continue
+				}
+			case <-refreshChan:
+			case <-ctx.Done():
+				return
+			}
+
+			hosts, paths, err := load()
+			if err != nil {
+				slog.Error("failed to reload inventory", "error", err)
+				modTimes = pathModTimes(slices.Collect(maps.Keys(modTimes))) // Wait for the next change before trying again
+			} else {
+				modTimes = pathModTimes(paths)
+			}
+			select {
+			case reloadChan <- Reload{Hosts: hosts, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return reloadChan
+}
+
+// pathModTimes returns the modification time of each path, using the zero time for paths that no longer exist
+func pathModTimes(paths []string) map[string]time.Time {
+	modTimes := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		} else {
+			modTimes[path] = time.Time{}
+		}
+	}
+	return modTimes
+}
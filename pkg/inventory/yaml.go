@@ -0,0 +1,169 @@
+package inventory
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// parseYAML reads an inventory of the form
+//
+//	vars:
+//	  username: some-user
+//	hosts:
+//	  host-1:
+//	    address: ubuntu-server-1
+//	groups:
+//	  web:
+//	    hosts: [host-1]
+//	    vars:
+//	      port: 2222
+//	include: [other-inventory.ini]
+//
+// where every key under hosts is the alias of a host entry, and vars apply to every host. Ansible inventories are
+// detected and read with parseAnsibleYAML
+func parseYAML(data []byte) (inventoryDefinition, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return inventoryDefinition{}, fmt.Errorf("failed to load yaml data: %s", err)
+	}
+	if len(document.Content) == 0 { // Empty file
+		return inventoryDefinition{}, nil
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return inventoryDefinition{}, fmt.Errorf("line %d: the top level of a yaml inventory must be a mapping", root.Line)
+	}
+	if isAnsibleYAML(root) {
+		return parseAnsibleYAML(root)
+	}
+
+	var inv inventoryDefinition
+	for i := 0; i < len(root.Content); i += 2 {
+		keyNode, valueNode := root.Content[i], root.Content[i+1]
+		var err error
+		switch keyNode.Value {
+		case "hosts":
+			inv.hosts, err = parseYAMLHosts(valueNode)
+		case "groups":
+			inv.groups, err = parseYAMLGroups(valueNode)
+		case "vars":
+			inv.vars, err = parseYAMLVars(valueNode)
+		case "include":
+			var includes string
+			includes, err = yamlScalarValue(valueNode)
+			inv.includes = splitList(includes)
+		default:
+			err = fmt.Errorf("line %d: unknown top level key %s", keyNode.Line, keyNode.Value)
+		}
+		if err != nil {
+			return inventoryDefinition{}, err
+		}
+	}
+	return inv, nil
+}
+
+func parseYAMLGroups(node *yaml.Node) ([]groupDefinition, error) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: groups must be a mapping of group names to their hosts and variables", node.Line)
+	}
+
+	var groups []groupDefinition
+	for i := 0; i < len(node.Content); i += 2 {
+		nameNode, groupNode := node.Content[i], node.Content[i+1]
+		group := groupDefinition{name: nameNode.Value}
+		if groupNode.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %d: group %s must be a mapping with hosts and vars", groupNode.Line, group.name)
+		}
+		for j := 0; j < len(groupNode.Content); j += 2 {
+			keyNode, valueNode := groupNode.Content[j], groupNode.Content[j+1]
+			var err error
+			switch keyNode.Value {
+			case "hosts":
+				var members string
+				members, err = yamlScalarValue(valueNode)
+				group.members = splitList(members)
+			case "vars":
+				group.vars, err = parseYAMLVars(valueNode)
+			default:
+				err = fmt.Errorf("line %d: unknown key %s", keyNode.Line, keyNode.Value)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("group %s: %s", group.name, err)
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func parseYAMLHosts(node *yaml.Node) ([]hostDefinition, error) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" { // hosts: with nothing under it
+		return nil, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: hosts must be a mapping of host aliases to their variables", node.Line)
+	}
+
+	var definitions []hostDefinition
+	for i := 0; i < len(node.Content); i += 2 {
+		aliasNode, varsNode := node.Content[i], node.Content[i+1]
+		definition := hostDefinition{alias: aliasNode.Value, line: aliasNode.Line}
+		vars, err := parseYAMLVars(varsNode)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %s", aliasNode.Value, err)
+		}
+		definition.vars = vars
+		definitions = append(definitions, definition)
+	}
+	return definitions, nil
+}
+
+// parseYAMLVars converts a mapping of variables into the same key/value strings the ini format produces.
+// Lists are joined with commas so that they are validated the same way as a comma separated ini value
+func parseYAMLVars(node *yaml.Node) ([]variable, error) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: host variables must be a mapping", node.Line)
+	}
+
+	var vars []variable
+	for i := 0; i < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		value, err := yamlScalarValue(valueNode)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %s", keyNode.Value, err)
+		}
+		vars = append(vars, variable{key: keyNode.Value, value: value, line: keyNode.Line})
+	}
+	return vars, nil
+}
+
+func yamlScalarValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!vault" && !isAnsibleVaultValue(node.Value) {
+			return "", fmt.Errorf("line %d: a !vault value must start with %s", node.Line, ansibleVaultHeader)
+		}
+		return node.Value, nil
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("line %d: lists may only contain plain values", item.Line)
+			}
+			values = append(values, item.Value)
+		}
+		return strings.Join(values, ","), nil
+	case yaml.AliasNode:
+		return yamlScalarValue(node.Alias)
+	default:
+		return "", fmt.Errorf("line %d: nested mappings are not supported as variable values", node.Line)
+	}
+}
@@ -0,0 +1,241 @@
+package statistics
+
+import (
+	"context"
+	"fmt"
+	"github.com/kreulenk/ez-monitor/pkg/inventory"
+	"log/slog"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Collector gathers statistics from every host it is connected to. The set of hosts can be updated while it runs as
+// the inventory changes, without interrupting the hosts that stay the same
+type Collector struct {
+	ctx           context.Context
+	statsChan     chan *HostStat
+	hostsChan     chan []inventory.Host
+	reloadErrChan chan error
+
+	// How hosts are connected to once the TUI has started, which is without prompting on the terminal
+	connectOpts connectOptions
+
+	updateMu sync.Mutex // Held while an update is applied so that updates from different sources do not interleave
+	mu       sync.Mutex
+	hosts    []inventory.Host              // Hosts being collected from, in inventory order
+	running  map[string]context.CancelFunc // Stops the collection of a host and closes its connection, by alias
+}
+
+// StartStatisticsCollection connects to every host and starts collecting from them. The first connection to each host
+// is made before it returns, so that any prompts happen before the TUI starts, and hosts that cannot be connected to
+// are retried in the background. Host keys and key passphrases are only prompted for during the first connection, after
+// which the prompt host key policy behaves as strict and encrypted keys need ssh_private_key_passphrase or an ssh agent
+func StartStatisticsCollection(ctx context.Context, inventoryInfo []inventory.Host, hostKeyPolicy HostKeyPolicy) *Collector {
+	c := &Collector{
+		ctx:           ctx,
+		statsChan:     make(chan *HostStat),
+		hostsChan:     make(chan []inventory.Host),
+		reloadErrChan: make(chan error),
+		connectOpts:   connectOptions{hostKeyPolicy: hostKeyPolicy},
+		hosts:         inventoryInfo,
+		running:       make(map[string]context.CancelFunc),
+	}
+	// We close the connections when the context cancels in collectHostStats
+	for _, attempt := range connectToHosts(inventoryInfo, connectOptions{hostKeyPolicy: hostKeyPolicy, interactive: true}) {
+		c.start(attempt)
+	}
+	return c
+}
+
+// Stats returns the channel every collected HostStat is sent on
+func (c *Collector) Stats() chan *HostStat {
+	return c.statsChan
+}
+
+// Hosts returns a channel that receives the full list of hosts being collected from whenever it changes
+func (c *Collector) Hosts() chan []inventory.Host {
+	return c.hostsChan
+}
+
+// ReloadErrors returns a channel that receives the error of every inventory reload that failed, and nil once the
+// inventory has been reloaded successfully again
+func (c *Collector) ReloadErrors() chan error {
+	return c.reloadErrChan
+}
+
+// WatchInventory applies every successful reload received until the channel is closed, passing on the errors of those
+// that failed
+func (c *Collector) WatchInventory(reloads <-chan inventory.Reload) {
+	failed := false
+	for reload := range reloads {
+		if reload.Err == nil {
+			c.UpdateHosts(reload.Hosts)
+		}
+		if reload.Err == nil && !failed {
+			continue
+		}
+		failed = reload.Err != nil
+		select {
+		case c.reloadErrChan <- reload.Err:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// UpdateHosts makes the hosts being collected from match the inventory. Hosts that were added are connected to, hosts
+// that were removed have their connection closed, and hosts whose connection information changed are reconnected.
+// Hosts that cannot be connected to are retried in the background
+func (c *Collector) UpdateHosts(hosts []inventory.Host) {
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	c.mu.Lock()
+	current := make(map[string]inventory.Host, len(c.hosts))
+	for _, host := range c.hosts {
+		current[host.Alias] = host
+	}
+	c.mu.Unlock()
+
+	var toConnect []inventory.Host
+	unchanged := make(map[string]bool)
+	for _, host := range hosts {
+		existing, ok := current[host.Alias]
+		if ok && reflect.DeepEqual(existing, host) {
+			unchanged[host.Alias] = true
+			continue
+		}
+		toConnect = append(toConnect, host)
+	}
+	if len(toConnect) == 0 && len(unchanged) == len(current) {
+		return
+	}
+
+	c.mu.Lock()
+	for alias, stop := range c.running {
+		if !unchanged[alias] { // Removed or changed
+			stop()
+			delete(c.running, alias)
+		}
+	}
+	c.mu.Unlock()
+
+	attempts := connectToHosts(toConnect, c.connectOpts)
+
+	c.mu.Lock()
+	c.hosts = slices.Clone(hosts)
+	c.mu.Unlock()
+
+	// The TUI drops stats for hosts it does not know about, so it is sent the new hosts before any of them are started
+	select {
+	case c.hostsChan <- slices.Clone(hosts):
+	case <-c.ctx.Done():
+	}
+	for _, attempt := range attempts {
+		c.start(attempt)
+	}
+}
+
+// start collects statistics from the host until the collector's context is done or the host is stopped. If the
+// connection attempt failed, or the connection is lost later on, the host is reconnected to with an exponential
+// backoff. Stats sent while reconnecting carry the attempt number and the error of the last attempt
+func (c *Collector) start(attempt connectionAttempt) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.mu.Lock()
+	c.running[attempt.host.Alias] = cancel
+	c.mu.Unlock()
+	go func() {
+		conn, err := attempt.conn, attempt.err
+		for {
+			for reconnectAttempt := 1; err != nil; reconnectAttempt++ {
+				// Let the TUI show why the host is unavailable in place of its stats
+				stat := &HostStat{
+					HostAlias:        attempt.host.Alias,
+					Address:          attempt.host.Address,
+					Timestamp:        time.Now(),
+					ConnectionError:  err,
+					ReconnectAttempt: reconnectAttempt,
+				}
+				if !c.send(ctx, stat) {
+					return
+				}
+				select {
+				case <-time.After(reconnectDelay(reconnectAttempt)):
+				case <-ctx.Done():
+					return
+				}
+				conn, err = connect(attempt.host, c.connectOpts)
+			}
+			err = c.collectHostStats(ctx, conn)
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("lost connection to host", "host", attempt.host.Alias, "error", err)
+		}
+	}()
+}
+
+// reconnectDelay is how long to wait before the given reconnect attempt. The delay doubles with every attempt up to
+// maxReconnectDelay, and is randomly shortened by up to half so that hosts that dropped together do not all reconnect
+// at the same moment
+func reconnectDelay(attempt int) time.Duration {
+	delay := maxReconnectDelay
+	if shift := attempt - 1; shift < 32 {
+		delay = min(baseReconnectDelay<<shift, maxReconnectDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// send passes a stat on to the TUI, returning false if the host was stopped first
+func (c *Collector) send(ctx context.Context, stat *HostStat) bool {
+	select {
+	case c.statsChan <- stat:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// collectHostStats collects from the host every interval until the host is stopped, returning nil, or the connection
+// is lost, returning why. The connection is closed either way
+func (c *Collector) collectHostStats(ctx context.Context, host ConnectionInfo) error {
+	defer host.connectionClient.Close()
+	closed := make(chan error, 1) // Receives once the connection has been closed from either end
+	go func() {
+		closed <- host.connectionClient.Wait()
+	}()
+
+	interval := host.InventoryInfo.Interval
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// A connection that dropped without being closed, such as when the host loses power, is only noticed by the
+		// host failing to answer
+		if err := keepAlive(host.connectionClient, keepAliveTimeout); err != nil {
+			return fmt.Errorf("connection lost: %s", err)
+		}
+		stat := getHostStats(host)
+		select {
+		case err := <-closed: // Every metric failed because the connection closed while collecting them
+			return fmt.Errorf("connection closed: %v", err)
+		default:
+		}
+		if !c.send(ctx, stat) {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case err := <-closed:
+			return fmt.Errorf("connection closed: %v", err)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
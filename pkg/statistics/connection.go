@@ -1,13 +1,19 @@
 package statistics
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/kreulenk/ez-monitor/pkg/inventory"
 	"github.com/mitchellh/go-homedir"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+	"log/slog"
+	"net"
 	"os"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -18,110 +24,284 @@ type ConnectionInfo struct {
 	connectionSession *ssh.Session
 }
 
-func connectToHosts(inventoryInfo []inventory.Host) ([]ConnectionInfo, error) {
-	var wg sync.WaitGroup
+// connectionAttempt is the result of connecting to a host, holding either the connection or why it failed
+type connectionAttempt struct {
+	host inventory.Host
+	conn ConnectionInfo
+	err  error
+}
 
-	errChan := make(chan error, len(inventoryInfo))
-	connChan := make(chan ConnectionInfo, len(inventoryInfo))
+// connectOptions control how hosts are connected to
+type connectOptions struct {
+	hostKeyPolicy HostKeyPolicy
+	// interactive allows prompting on the terminal, which is only possible before the TUI starts. Without it, encrypted
+	// keys that have no passphrase fail to load and the prompt host key policy behaves as strict
+	interactive bool
+}
 
-	for _, host := range inventoryInfo {
+// connectToHosts connects to every host concurrently. Every host is returned, in the order given, whether or not the
+// connection succeeded so that one unreachable host does not prevent the others from being collected from
+func connectToHosts(inventoryInfo []inventory.Host, opts connectOptions) []connectionAttempt {
+	var wg sync.WaitGroup
+	attempts := make([]connectionAttempt, len(inventoryInfo))
+	for i, host := range inventoryInfo {
 		wg.Add(1)
-		go func(host inventory.Host) {
+		go func(i int, host inventory.Host) {
 			defer wg.Done()
-			client, session, err := connectToHost(host)
-			if err != nil {
-				errChan <- err
-				return
-			}
-			connChan <- ConnectionInfo{
-				InventoryInfo:     host,
-				connectionClient:  client,
-				connectionSession: session,
-			}
-		}(host)
+			conn, err := connect(host, opts)
+			attempts[i] = connectionAttempt{host: host, conn: conn, err: err}
+		}(i, host)
 	}
 	wg.Wait()
-	close(errChan)
-	close(connChan)
+	return attempts
+}
 
-	if len(errChan) != 0 {
-		var errs []error
-		for e := range errChan {
-			errs = append(errs, e)
-		}
-		return nil, errors.Join(errs...)
+func connect(host inventory.Host, opts connectOptions) (ConnectionInfo, error) {
+	client, session, err := connectToHost(host, opts)
+	if err != nil {
+		return ConnectionInfo{}, err
 	}
+	return ConnectionInfo{
+		InventoryInfo:     host,
+		connectionClient:  client,
+		connectionSession: session,
+	}, nil
+}
 
-	var hosts []ConnectionInfo
-	for host := range connChan {
-		hosts = append(hosts, host)
+// keepAlive checks that the host still answers over the connection. Servers reply to the keepalive request even
+// though they do not support it, which is all that is needed
+func keepAlive(client *ssh.Client, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("no response from the host after %s", timeout)
 	}
-
-	return hosts, nil
 }
 
-func getAuthMethods(host inventory.Host) ([]ssh.AuthMethod, error) {
+// getAuthMethods returns the ways the host can be authenticated with. The returned function closes the connection to
+// the ssh agent, if one was opened, and must be called once the SSH handshake has finished
+func getAuthMethods(host inventory.Host, interactive bool) ([]ssh.AuthMethod, func(), error) {
 	var authMethods []ssh.AuthMethod
 
 	if host.Password != "" {
-		authMethods = append(authMethods, ssh.Password(host.Password))
+		authMethods = append(authMethods, ssh.Password(host.Password), ssh.KeyboardInteractive(answerWithPassword(host.Password)))
 	}
 
+	// Every key is offered through a single callback as the ssh package only tries the first method of each type
+	var signers []ssh.Signer
 	if host.SshPrivateKeyFile != "" {
 		dir, err := homedir.Expand(host.SshPrivateKeyFile)
 		if err != nil {
-			return nil, fmt.Errorf("failed to expand SSH private key file %s: %s", host.SshPrivateKeyFile, err)
+			return nil, nil, fmt.Errorf("failed to expand SSH private key file %s: %s", host.SshPrivateKeyFile, err)
 		}
 
-		key, err := os.ReadFile(dir)
+		signer, err := readPrivateKey(dir, host.SshPrivateKeyPassphrase, interactive)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read SSH private key file %s: %s", dir, err)
+			return nil, nil, err
 		}
+		signers = append(signers, signer)
+	}
 
-		signer, err := ssh.ParsePrivateKey(key)
+	var cert *ssh.Certificate
+	if host.SshCertificateFile != "" {
+		var err error
+		cert, err = readCertificate(host.SshCertificateFile)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse SSH private key file %s: %s", dir, err)
+			return nil, nil, err
+		}
+		if len(signers) > 0 {
+			certSigner, err := ssh.NewCertSigner(cert, signers[0])
+			if err != nil {
+				return nil, nil, fmt.Errorf("SSH certificate file %s does not match SSH private key file %s: %s",
+					host.SshCertificateFile, host.SshPrivateKeyFile, err)
+			}
+			signers = append([]ssh.Signer{certSigner}, signers...)
+			cert = nil
 		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
 	}
 
-	return authMethods, nil
+	agentClient, closeAgent, err := connectToAgent(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(signers) > 0 || agentClient != nil {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if agentClient == nil {
+				return signers, nil
+			}
+			agentSigners, err := agentClient.Signers()
+			if err != nil {
+				return nil, fmt.Errorf("failed to list ssh agent keys: %s", err)
+			}
+			if cert != nil { // Without a private key file, the certificate is for a key held by the agent
+				agentSigners = withCertSigner(cert, agentSigners)
+			}
+			return append(slices.Clone(signers), agentSigners...), nil
+		}))
+	}
+
+	return authMethods, closeAgent, nil
 }
 
-func connectToHost(host inventory.Host) (*ssh.Client, *ssh.Session, error) {
-	authMethods, err := getAuthMethods(host)
+// answerWithPassword answers keyboard-interactive challenges by giving the password to every prompt that hides its
+// input, which is how PAM asks for a password, and leaving any other prompts empty
+func answerWithPassword(password string) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			if !echos[i] {
+				answers[i] = password
+			}
+		}
+		return answers, nil
+	}
+}
+
+// readCertificate reads an OpenSSH user certificate, returning an error if it is not currently valid
+func readCertificate(filename string) (*ssh.Certificate, error) {
+	path, err := homedir.Expand(filename)
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("failed to expand SSH certificate file %s: %s", filename, err)
 	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH certificate file %s: %s", filename, err)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH certificate file %s: %s", filename, err)
+	}
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("SSH certificate file %s is not an OpenSSH user certificate", filename)
+	}
+
+	now := uint64(time.Now().Unix())
+	if cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore {
+		return nil, fmt.Errorf("SSH certificate file %s expired at %s", filename,
+			time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339))
+	}
+	if now < cert.ValidAfter {
+		return nil, fmt.Errorf("SSH certificate file %s is not valid until %s", filename,
+			time.Unix(int64(cert.ValidAfter), 0).Format(time.RFC3339))
+	}
+	return cert, nil
+}
+
+// withCertSigner puts a signer for the certificate first if one of the signers holds the certificate's key
+func withCertSigner(cert *ssh.Certificate, signers []ssh.Signer) []ssh.Signer {
+	certKey := cert.Key.Marshal()
+	for _, signer := range signers {
+		if !bytes.Equal(signer.PublicKey().Marshal(), certKey) {
+			continue
+		}
+		if certSigner, err := ssh.NewCertSigner(cert, signer); err == nil {
+			return append([]ssh.Signer{certSigner}, signers...)
+		}
+	}
+	slog.Warn("No ssh agent key matches the SSH certificate", "key", ssh.FingerprintSHA256(cert.Key))
+	return signers
+}
+
+// Passphrases entered for encrypted private keys by the key's path, so that each key is only prompted for once no
+// matter how many hosts use it
+var (
+	keyPassphrases   = make(map[string]string)
+	keyPassphrasesMu sync.Mutex
+)
 
-	knownHostsFile, err := homedir.Expand("~/.ssh/known_hosts")
+// readPrivateKey parses a private key file. Encrypted keys are decrypted with the passphrase if one is given, otherwise
+// the passphrase is prompted for if interactive
+func readPrivateKey(path, passphrase string, interactive bool) (ssh.Signer, error) {
+	key, err := os.ReadFile(path)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to expand known_hosts file: %s", err)
+		return nil, fmt.Errorf("failed to read SSH private key file %s: %s", path, err)
 	}
-	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+
+	signer, err := ssh.ParsePrivateKey(key)
+	var passphraseMissing *ssh.PassphraseMissingError
+	if !errors.As(err, &passphraseMissing) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH private key file %s: %s", path, err)
+		}
+		return signer, nil
+	}
+
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt SSH private key file %s with ssh_private_key_passphrase: %s", path, err)
+		}
+		return signer, nil
+	}
+
+	// Hosts are connected to concurrently, so the lock also keeps prompts for different keys from overlapping
+	keyPassphrasesMu.Lock()
+	defer keyPassphrasesMu.Unlock()
+	if cached, ok := keyPassphrases[path]; ok {
+		return ssh.ParsePrivateKeyWithPassphrase(key, []byte(cached))
+	}
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+	if !interactive || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("SSH private key file %s is encrypted but there is no terminal to prompt for its passphrase. "+
+			"Set ssh_private_key_passphrase in the inventory or add the key to an ssh agent", path)
+	}
+	fmt.Printf("Enter the passphrase for the SSH private key %s:\n", path)
+	passphraseBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println() // Print a newline after password input
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load known_hosts file: %s", err)
+		return nil, fmt.Errorf("failed to read passphrase: %s", err)
 	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passphraseBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SSH private key file %s: %s", path, err)
+	}
+	keyPassphrases[path] = string(passphraseBytes)
+	return signer, nil
+}
 
-	sshConfig := &ssh.ClientConfig{
-		User:            host.Username,
-		Auth:            authMethods,
-		HostKeyCallback: hostKeyCallback,
-		Timeout:         time.Second * 10,
+// connectToAgent connects to the ssh agent of the host, which is the one listening on SSH_AUTH_SOCK unless the host
+// sets its own socket. If the host does not set a socket and SSH_AUTH_SOCK is not usable, no agent is used
+func connectToAgent(host inventory.Host) (agent.ExtendedAgent, func(), error) {
+	noAgent := func() {}
+	if host.DisableSshAgent {
+		return nil, noAgent, nil
 	}
-	port := 22
-	if host.Port != 0 {
-		port = host.Port
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if host.IdentityAgent != "" {
+		var err error
+		socket, err = homedir.Expand(host.IdentityAgent)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to expand identity agent %s: %s", host.IdentityAgent, err)
+		}
+	}
+	if socket == "" {
+		return nil, noAgent, nil
 	}
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", host.Address, port), sshConfig)
-	// This type of error checking could break on dependency bumps but this default error message for the known_hosts check failing isn't good enough
-	if err != nil && err.Error() == "ssh: handshake failed: knownhosts: key is unknown" {
-		return nil, nil, fmt.Errorf("failed to connect to %s: ssh: handshake failed: host's key in %s file is not yet present. You can simply ssh onto the host and accept the key to add it", host.Alias, knownHostsFile)
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		if host.IdentityAgent != "" {
+			return nil, nil, fmt.Errorf("failed to connect to ssh agent %s for %s: %s", socket, host.Alias, err)
+		}
+		slog.Warn("failed to connect to ssh agent from SSH_AUTH_SOCK", "socket", socket, "error", err)
+		return nil, noAgent, nil
 	}
+	return agent.NewClient(conn), func() { conn.Close() }, nil
+}
 
+func connectToHost(host inventory.Host, opts connectOptions) (*ssh.Client, *ssh.Session, error) {
+	client, err := dialHost(host, opts)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to connect to %s: %s", host.Alias, err)
+		return nil, nil, err
 	}
 
 	session, err := client.NewSession()
@@ -132,3 +312,151 @@ func connectToHost(host inventory.Host) (*ssh.Client, *ssh.Session, error) {
 
 	return client, session, nil
 }
+
+// dialHost opens an SSH connection to the host, first connecting to its jump host if it has one
+func dialHost(host inventory.Host, opts connectOptions) (*ssh.Client, error) {
+	authMethods, closeAgent, err := getAuthMethods(host, opts.interactive)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent() // The agent is only needed while authenticating
+
+	port := 22
+	if host.Port != 0 {
+		port = host.Port
+	}
+	address := net.JoinHostPort(host.Address, strconv.Itoa(port))
+
+	checkHostKey, hostKeyAlgorithms, err := hostKeyCallback(host, address, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConfig := &ssh.ClientConfig{
+		User:              host.Username,
+		Auth:              authMethods,
+		HostKeyCallback:   checkHostKey,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           time.Second * 10,
+	}
+
+	var client *ssh.Client
+	if host.JumpHost == nil {
+		client, err = ssh.Dial("tcp", address, sshConfig)
+	} else {
+		client, err = dialThroughJumpHost(*host.JumpHost, address, sshConfig, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %s", host.Alias, err)
+	}
+
+	return client, nil
+}
+
+// Connections to jump hosts by jumpHostKey, shared by every host that is reached through the same jump host so that
+// a bastion is only connected to once
+var (
+	jumpConnections   = make(map[string]*jumpConnection)
+	jumpConnectionsMu sync.Mutex
+)
+
+// jumpConnection is a connection to a jump host along with the number of connections tunneled through it. ready is
+// closed once the connection has been dialed, after which client or err is set
+type jumpConnection struct {
+	client *ssh.Client
+	err    error
+	ready  chan struct{}
+	refs   int
+}
+
+// jumpHostKey identifies a connection to a jump host. Jump hosts with the same key can share a connection
+func jumpHostKey(host inventory.Host) string {
+	key := fmt.Sprintf("%s@%s:%d:%s:%s", host.Username, host.Address, host.Port, host.SshPrivateKeyFile, host.KnownHostsFile)
+	if host.JumpHost != nil {
+		key += " via " + jumpHostKey(*host.JumpHost)
+	}
+	return key
+}
+
+// acquireJumpClient returns a connection to the jump host, dialing it if there is not already one open. The returned
+// function must be called once the connection is no longer needed and closes it when nothing else is using it
+func acquireJumpClient(jumpHost inventory.Host, opts connectOptions) (*ssh.Client, func(), error) {
+	key := jumpHostKey(jumpHost)
+	jumpConnectionsMu.Lock()
+	jc, ok := jumpConnections[key]
+	if !ok {
+		jc = &jumpConnection{ready: make(chan struct{})}
+		jumpConnections[key] = jc
+	}
+	jc.refs++
+	jumpConnectionsMu.Unlock()
+
+	release := func() {
+		jumpConnectionsMu.Lock()
+		defer jumpConnectionsMu.Unlock()
+		jc.refs--
+		if jc.refs > 0 {
+			return
+		}
+		if jumpConnections[key] == jc {
+			delete(jumpConnections, key)
+		}
+		if jc.client != nil {
+			jc.client.Close()
+		}
+	}
+
+	if !ok { // Dialed outside the lock as connecting to a jump host can prompt for a key passphrase
+		jc.client, jc.err = dialHost(jumpHost, opts)
+		if jc.err == nil {
+			go func() { // Let the next host reconnect to the jump host if its connection drops
+				jc.client.Wait()
+				jumpConnectionsMu.Lock()
+				if jumpConnections[key] == jc {
+					delete(jumpConnections, key)
+				}
+				jumpConnectionsMu.Unlock()
+			}()
+		} else {
+			jumpConnectionsMu.Lock()
+			delete(jumpConnections, key) // Retried by the next host rather than every host sharing the failure
+			jumpConnectionsMu.Unlock()
+		}
+		close(jc.ready)
+	}
+	<-jc.ready
+	if jc.err != nil {
+		release()
+		return nil, nil, jc.err
+	}
+	return jc.client, release, nil
+}
+
+// dialThroughJumpHost tunnels a connection to address through the jump host. The connection to the jump host is shared
+// with every other host behind it and is closed once none of their connections are open
+func dialThroughJumpHost(jumpHost inventory.Host, address string, sshConfig *ssh.ClientConfig, opts connectOptions) (*ssh.Client, error) {
+	jumpClient, release, err := acquireJumpClient(jumpHost, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to jump host: %s", err)
+	}
+
+	conn, err := jumpClient.Dial("tcp", address)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to reach %s through jump host %s: %s", address, jumpHost.Alias, err)
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, sshConfig)
+	if err != nil {
+		conn.Close()
+		release()
+		return nil, err
+	}
+
+	client := ssh.NewClient(clientConn, chans, reqs)
+	go func() {
+		client.Wait()
+		release()
+	}()
+	return client, nil
+}
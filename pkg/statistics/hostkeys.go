@@ -0,0 +1,227 @@
+package statistics
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/kreulenk/ez-monitor/pkg/inventory"
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// HostKeyPolicy decides what happens when a host's key is not in its known_hosts file. Keys that differ from the one
+// in the known_hosts file are always rejected
+type HostKeyPolicy string
+
+const (
+	// HostKeyPolicyStrict refuses to connect to hosts whose key is not already known
+	HostKeyPolicyStrict HostKeyPolicy = "strict"
+	// HostKeyPolicyAcceptNew adds the key of hosts that are not yet known to the known_hosts file without asking
+	HostKeyPolicyAcceptNew HostKeyPolicy = "accept-new"
+	// HostKeyPolicyPrompt shows the fingerprint of keys that are not yet known and asks whether to trust them
+	HostKeyPolicyPrompt HostKeyPolicy = "prompt"
+)
+
+// defaultKnownHostsFile is used for hosts that do not set known_hosts_file
+const defaultKnownHostsFile = "~/.ssh/known_hosts"
+
+// ParseHostKeyPolicy returns the HostKeyPolicy with the given name
+func ParseHostKeyPolicy(name string) (HostKeyPolicy, error) {
+	switch policy := HostKeyPolicy(strings.ToLower(name)); policy {
+	case HostKeyPolicyStrict, HostKeyPolicyAcceptNew, HostKeyPolicyPrompt:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown host key policy %s. Use strict, accept-new or prompt", name)
+	}
+}
+
+// unattended is the policy to use when there is no terminal to prompt on, such as once the TUI has started
+func (p HostKeyPolicy) unattended() HostKeyPolicy {
+	if p == HostKeyPolicyPrompt {
+		return HostKeyPolicyStrict
+	}
+	return p
+}
+
+// knownHostsMu is held while a host key is checked so that a key accepted for one host is seen by any other host
+// sharing its address, and so that writes to the known_hosts files do not interleave
+var knownHostsMu sync.Mutex
+
+// terminalMu is held while prompting on the terminal so that prompts for different hosts do not overlap
+var terminalMu sync.Mutex
+
+// hostKeyCallback checks the keys presented by the host against its known_hosts file, handling keys that are not yet
+// known according to the host key policy. It also returns the host key algorithms to negotiate, which prefer the types
+// of key the host is already known by
+func hostKeyCallback(host inventory.Host, address string, opts connectOptions) (ssh.HostKeyCallback, []string, error) {
+	policy := opts.hostKeyPolicy
+	if !opts.interactive {
+		policy = policy.unattended()
+	}
+	filename := host.KnownHostsFile
+	if filename == "" {
+		filename = defaultKnownHostsFile
+	}
+	knownHostsFile, err := homedir.Expand(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to expand known_hosts file %s: %s", filename, err)
+	}
+	if _, err := os.Stat(knownHostsFile); err != nil && (policy == HostKeyPolicyStrict || !os.IsNotExist(err)) {
+		return nil, nil, fmt.Errorf("failed to load known_hosts file: %s", err)
+	}
+
+	knownHostsMu.Lock()
+	algorithms := knownHostKeyAlgorithms(knownHostsFile, address)
+	knownHostsMu.Unlock()
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		// The file is read for every check as keys may have been added to it since the last one
+		err := checkKnownHosts(knownHostsFile, hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		// A host known by other types of key, but not this one, has not changed its key
+		sameType := slices.DeleteFunc(keyErr.Want, func(known knownhosts.KnownKey) bool { return known.Key.Type() != key.Type() })
+		if len(sameType) > 0 {
+			return changedHostKeyError(host, hostname, key, sameType, knownHostsFile)
+		}
+
+		switch policy {
+		case HostKeyPolicyAcceptNew:
+			return addKnownHost(knownHostsFile, hostname, key)
+		case HostKeyPolicyPrompt:
+			accepted, err := promptForHostKey(host, hostname, key)
+			if err != nil {
+				return err
+			}
+			if !accepted {
+				return fmt.Errorf("host key %s for %s was not accepted", ssh.FingerprintSHA256(key), hostname)
+			}
+			return addKnownHost(knownHostsFile, hostname, key)
+		default:
+			return fmt.Errorf("host key %s for %s is not in known_hosts file %s. Check the fingerprint and add the key "+
+				"by connecting with ssh, or run with --host-key-policy prompt or accept-new", ssh.FingerprintSHA256(key), hostname, knownHostsFile)
+		}
+	}, algorithms, nil
+}
+
+// probeHostKey is a key that no host has, for listing the keys a host is known by
+var probeHostKey, _ = ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+
+// knownHostKeyAlgorithms returns every supported host key algorithm, led by those for the types of key the host is
+// already known by. As with ssh, this makes a host that has several keys present the one that can be checked, rather
+// than one of a type that is not in the known_hosts file. Nil, for the default order, is returned for unknown hosts
+func knownHostKeyAlgorithms(knownHostsFile, address string) []string {
+	var keyErr *knownhosts.KeyError
+	if err := checkKnownHosts(knownHostsFile, address, &net.TCPAddr{}, probeHostKey); !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+		return nil
+	}
+
+	supported := ssh.SupportedAlgorithms().HostKeys
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		keyAlgorithms := []string{known.Key.Type()}
+		if known.Key.Type() == ssh.KeyAlgoRSA { // RSA keys sign with SHA-2 unless the host only supports SHA-1
+			keyAlgorithms = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		for _, algorithm := range keyAlgorithms {
+			if slices.Contains(supported, algorithm) && !slices.Contains(algorithms, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	for _, algorithm := range supported {
+		if !slices.Contains(algorithms, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	return algorithms
+}
+
+// checkKnownHosts checks the key against the known_hosts file. A file that does not exist has no known hosts
+func checkKnownHosts(knownHostsFile, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if _, err := os.Stat(knownHostsFile); os.IsNotExist(err) {
+		return &knownhosts.KeyError{}
+	}
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load known_hosts file: %s", err)
+	}
+	return callback(hostname, remote, key)
+}
+
+// changedHostKeyError explains that the host presented a different key to the one it is known by, which happens when
+// the host was reinstalled but could also be someone intercepting the connection
+func changedHostKeyError(host inventory.Host, hostname string, key ssh.PublicKey, want []knownhosts.KnownKey, knownHostsFile string) error {
+	known := make([]string, len(want))
+	for i, knownKey := range want {
+		known[i] = fmt.Sprintf("%s %s (%s:%d)", knownKey.Key.Type(), ssh.FingerprintSHA256(knownKey.Key), knownKey.Filename, knownKey.Line)
+	}
+	return fmt.Errorf("HOST KEY FOR %s HAS CHANGED. Someone could be intercepting the connection (man-in-the-middle attack), "+
+		"or the host's key may have been replaced. The host sent %s %s but is known by %s. If the change is expected, "+
+		"remove the old key from %s", host.Alias, key.Type(), ssh.FingerprintSHA256(key), strings.Join(known, ", "), knownHostsFile)
+}
+
+// promptForHostKey shows the fingerprint of a key that is not yet known and asks whether it should be trusted
+func promptForHostKey(host inventory.Host, hostname string, key ssh.PublicKey) (bool, error) {
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, fmt.Errorf("host key %s for %s is not in the known_hosts file and there is no terminal to prompt "+
+			"for whether to trust it", ssh.FingerprintSHA256(key), hostname)
+	}
+	fmt.Printf("The authenticity of host %s (%s) can't be established.\n", host.Alias, hostname)
+	fmt.Printf("%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
+	for {
+		fmt.Print("Are you sure you want to continue connecting (yes/no)? ")
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return false, fmt.Errorf("failed to read answer: %s", err)
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "yes", "y":
+			return true, nil
+		case "no", "n":
+			return false, nil
+		}
+	}
+}
+
+// addKnownHost appends the key to the known_hosts file, creating the file if it does not exist
+func addKnownHost(knownHostsFile, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(knownHostsFile), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for known_hosts file: %s", err)
+	}
+	existing, err := os.ReadFile(knownHostsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read known_hosts file: %s", err)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n"
+	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		line = "\n" + line
+	}
+
+	file, err := os.OpenFile(knownHostsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts file: %s", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(line); err != nil {
+		return fmt.Errorf("failed to add host key to known_hosts file: %s", err)
+	}
+	return nil
+}
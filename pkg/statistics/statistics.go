@@ -2,12 +2,14 @@ package statistics
 
 import (
 	"bytes"
-	"context"
+	"errors"
 	"fmt"
 	"github.com/kreulenk/ez-monitor/pkg/inventory"
 	"golang.org/x/crypto/ssh"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +21,10 @@ type HistoricalDataPoint struct {
 type HostStat struct {
 	HostAlias string
 	Address   string
+	// ConnectionError is set when the host could not be connected to, in which case no metrics were collected
+	ConnectionError error
+	// ReconnectAttempt is the number of the next attempt to connect to the host when ConnectionError is set
+	ReconnectAttempt int
 
 	CPUUsage float64
 	CPUError error
@@ -27,54 +33,58 @@ type HostStat struct {
 	MemoryTotal float64
 	MemoryError error
 
-	DiskUsage float64
+	DiskUsage float64 // Summed across every filesystem in Disks
 	DiskTotal float64
+	Disks     []DiskStat
 	DiskError error
 
 	NetworkingMBReceived float64
 	NetworkingMBSent     float64
 	NetworkingError      error
 
+	ProcessIOMBRead    float64 // Read from storage by every running process, summed over their lifetimes
+	ProcessIOMBWritten float64
+	ProcessIOError     error
+
 	Timestamp time.Time
 }
 
-func StartStatisticsCollection(ctx context.Context, inventoryInfo []inventory.Host) (chan *HostStat, error) {
-	hosts, err := connectToHosts(inventoryInfo) // We close the connections when the context cancels in the loop below
-	if err != nil {
-		return nil, err
-	}
-
-	statsChan := make(chan *HostStat)
-	for _, host := range hosts {
-		go func(host ConnectionInfo, statsChan chan *HostStat) {
-			stat := getHostStats(host)
-			statsChan <- stat
-			ticker := time.NewTicker(time.Second * 2)
-			for {
-				select {
-				case <-ticker.C:
-					stat := getHostStats(host)
-					statsChan <- stat
-				case <-ctx.Done():
-					host.connectionClient.Close()
-					return
-				}
-			}
-		}(host, statsChan)
-	}
-	return statsChan, nil
-}
-
-func getCPUUsage(client *ssh.Client) (float64, error) {
+// DiskStat is the usage of a single filesystem in megabytes
+type DiskStat struct {
+	Mount string
+	Used  float64
+	Total float64
+}
+
+// ErrMetricDisabled is set as the error of any metric that the host's inventory entry does not collect
+var ErrMetricDisabled = errors.New("collection is disabled for this host")
+
+// ErrMetricNeedsBecome is set as the error of privileged metrics that the host asks for without setting become
+var ErrMetricNeedsBecome = errors.New("collection needs root privileges. Set become=sudo for this host")
+
+// defaultInterval is how often stats are collected from hosts that do not set an interval
+const defaultInterval = time.Second * 2
+
+// Reconnecting to a host that could not be connected to, or whose connection was lost, starts after baseReconnectDelay
+// and backs off to maxReconnectDelay between attempts
+const (
+	baseReconnectDelay = time.Second * 2
+	maxReconnectDelay  = time.Minute * 2
+)
+
+// keepAliveTimeout is how long a host has to answer a keepalive before its connection is considered lost
+const keepAliveTimeout = time.Second * 15
+
+func getCPUUsage(client *ssh.Client, opts commandOptions) (float64, error) {
 	command := "mpstat 1 1 | awk '$12 ~ /[0-9.]+/ {print 100 - $12}' | tail -1"
 
 	alternativeCommand := "top -bn2 -d1 | grep '%Cpu' | tail -1 | awk '{print $2 + $4}'"
 
-	output, err := executeCommand(client, command)
+	output, err := executeCommand(client, command, opts)
 	if err != nil {
 		// Try alternative command
 		var errAlt error
-		output, errAlt = executeCommand(client, alternativeCommand)
+		output, errAlt = executeCommand(client, alternativeCommand, opts)
 		if errAlt != nil {
 			return 0, fmt.Errorf("failed to execute command %s: %s: failed to execute alternative command: %s: %s", command, err, alternativeCommand, errAlt)
 		}
@@ -87,9 +97,9 @@ func getCPUUsage(client *ssh.Client) (float64, error) {
 	return cpuUsage, nil
 }
 
-func getMemoryUsage(client *ssh.Client) (used float64, total float64, err error) {
+func getMemoryUsage(client *ssh.Client, opts commandOptions) (used float64, total float64, err error) {
 	command := "free -m | grep 'Mem:'"
-	output, err := executeCommand(client, command)
+	output, err := executeCommand(client, command, opts)
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to execute command %s: %s", command, err)
 	}
@@ -111,33 +121,53 @@ func getMemoryUsage(client *ssh.Client) (used float64, total float64, err error)
 	return used, total, nil
 }
 
-func getDiskUsage(client *ssh.Client) (used float64, total float64, err error) {
-	command := "df -m --output=used,size / | tail -1"
-	output, err := executeCommand(client, command)
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to execute command %s: %s", command, err)
+// getDiskUsage returns the usage of the filesystem of each mount along with their sum. Mounts that are on the same
+// filesystem are only counted once
+func getDiskUsage(client *ssh.Client, mounts []string, opts commandOptions) (used float64, total float64, disks []DiskStat, err error) {
+	if len(mounts) == 0 {
+		mounts = []string{"/"}
 	}
-	fields := strings.Fields(output)
-	if len(fields) < 2 {
-		return 0, 0, fmt.Errorf("unexpected output format from df command to get disk usage: %s", output)
+	quotedMounts := make([]string, len(mounts))
+	for i, mount := range mounts {
+		quotedMounts[i] = shellQuote(mount)
 	}
-
-	used, err = strconv.ParseFloat(fields[0], 64)
+	command := fmt.Sprintf("df -m --output=used,size,target -- %s | tail -n +2", strings.Join(quotedMounts, " "))
+	output, err := executeCommand(client, command, opts)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to parse used disk space: %s", err)
+		return 0, 0, nil, fmt.Errorf("failed to execute command %s: %s", command, err)
 	}
 
-	total, err = strconv.ParseFloat(fields[1], 64)
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to parse total disk space: %s", err)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return 0, 0, nil, fmt.Errorf("unexpected output format from df command to get disk usage: %s", output)
+		}
+		disk := DiskStat{Mount: strings.Join(fields[2:], " ")}
+		if slices.ContainsFunc(disks, func(existing DiskStat) bool { return existing.Mount == disk.Mount }) {
+			continue
+		}
+
+		disk.Used, err = strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("failed to parse used disk space: %s", err)
+		}
+
+		disk.Total, err = strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("failed to parse total disk space: %s", err)
+		}
+
+		used += disk.Used
+		total += disk.Total
+		disks = append(disks, disk)
 	}
 
-	return used, total, nil
+	return used, total, disks, nil
 }
 
-func getNetworkingUsage(client *ssh.Client) (sent float64, received float64, err error) {
+func getNetworkingUsage(client *ssh.Client, opts commandOptions) (sent float64, received float64, err error) {
 	command := "ip -s link show | awk '/^[0-9]+: / {iface=$2} iface!=\"lo:\" && $1 ~ /^[0-9]+$/ {rx+=$1; getline; tx+=$1} END {print rx, tx}'"
-	output, err := executeCommand(client, command)
+	output, err := executeCommand(client, command, opts)
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to execute command %s: %s", command, err)
 	}
@@ -159,22 +189,86 @@ func getNetworkingUsage(client *ssh.Client) (sent float64, received float64, err
 	return sent / 1024 / 1024, received / 1024 / 1024, nil
 }
 
+// getProcessIOUsage returns the bytes read and written by every process from /proc/<pid>/io, which needs root to read
+// for processes that belong to other users. Processes that exit while they are being read are skipped
+func getProcessIOUsage(client *ssh.Client, opts commandOptions) (read float64, written float64, err error) {
+	command := "cat /proc/[0-9]*/io 2>/dev/null | awk '$1 == \"read_bytes:\" {r+=$2} $1 == \"write_bytes:\" {w+=$2} END {printf \"%.0f %.0f\\n\", r, w}'"
+	output, err := executeCommand(client, command, opts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to execute command %s: %s", command, err)
+	}
+	fields := strings.Fields(output)
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("unexpected output format from /proc to get process io: %s", output)
+	}
+
+	read, err = strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse process io read bytes: %s", err)
+	}
+
+	written, err = strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse process io written bytes: %s", err)
+	}
+
+	return read / 1024 / 1024, written / 1024 / 1024, nil
+}
+
 func getHostStats(host ConnectionInfo) *HostStat {
+	inventoryInfo := host.InventoryInfo
+	// Metrics collected with root privileges run their commands through sudo
+	opts := func(metric string) commandOptions {
+		return commandOptions{
+			timeout:      inventoryInfo.CommandTimeout,
+			sudo:         inventoryInfo.BecomesForMetric(metric),
+			sudoPassword: inventoryInfo.BecomePassword,
+		}
+	}
 	stats := &HostStat{
-		HostAlias: host.InventoryInfo.Alias,
-		Address:   host.InventoryInfo.Address,
-		Timestamp: time.Now(),
+		HostAlias:       inventoryInfo.Alias,
+		Address:         inventoryInfo.Address,
+		Timestamp:       time.Now(),
+		CPUError:        ErrMetricDisabled,
+		MemoryError:     ErrMetricDisabled,
+		DiskError:       ErrMetricDisabled,
+		NetworkingError: ErrMetricDisabled,
+		ProcessIOError:  ErrMetricDisabled,
 	}
 
-	stats.CPUUsage, stats.CPUError = getCPUUsage(host.connectionClient)
-	stats.MemoryUsage, stats.MemoryTotal, stats.MemoryError = getMemoryUsage(host.connectionClient)
-	stats.DiskUsage, stats.DiskTotal, stats.DiskError = getDiskUsage(host.connectionClient)
-	stats.NetworkingMBSent, stats.NetworkingMBReceived, stats.NetworkingError = getNetworkingUsage(host.connectionClient)
+	if inventoryInfo.CollectsMetric(inventory.MetricCPU) {
+		stats.CPUUsage, stats.CPUError = getCPUUsage(host.connectionClient, opts(inventory.MetricCPU))
+	}
+	if inventoryInfo.CollectsMetric(inventory.MetricMemory) {
+		stats.MemoryUsage, stats.MemoryTotal, stats.MemoryError = getMemoryUsage(host.connectionClient, opts(inventory.MetricMemory))
+	}
+	if inventoryInfo.CollectsMetric(inventory.MetricDisk) {
+		stats.DiskUsage, stats.DiskTotal, stats.Disks, stats.DiskError = getDiskUsage(host.connectionClient, inventoryInfo.DiskMounts, opts(inventory.MetricDisk))
+	}
+	if inventoryInfo.CollectsMetric(inventory.MetricNetwork) {
+		stats.NetworkingMBSent, stats.NetworkingMBReceived, stats.NetworkingError = getNetworkingUsage(host.connectionClient, opts(inventory.MetricNetwork))
+	}
+	if inventoryInfo.CollectsMetric(inventory.MetricProcessIO) {
+		if inventoryInfo.BecomesForMetric(inventory.MetricProcessIO) {
+			stats.ProcessIOMBRead, stats.ProcessIOMBWritten, stats.ProcessIOError = getProcessIOUsage(host.connectionClient, opts(inventory.MetricProcessIO))
+		} else {
+			stats.ProcessIOError = ErrMetricNeedsBecome
+		}
+	}
 
 	return stats
 }
 
-func executeCommand(client *ssh.Client, command string) (string, error) {
+// commandOptions control how executeCommand runs a command
+type commandOptions struct {
+	timeout      time.Duration // How long the command may run for, zero for no limit
+	sudo         bool          // Run the command as root with sudo
+	sudoPassword string        // Given to sudo if it asks for a password
+}
+
+// executeCommand runs the command in a new session. If the command has not finished after the timeout, the session is
+// closed and an error returned. A zero timeout waits for as long as the command takes
+func executeCommand(client *ssh.Client, command string, opts commandOptions) (string, error) {
 	session, err := client.NewSession()
 	if err != nil {
 		return "", fmt.Errorf("failed to create session: %s", err)
@@ -183,9 +277,101 @@ func executeCommand(client *ssh.Client, command string) (string, error) {
 	var stdout, stderr bytes.Buffer
 	session.Stdout = &stdout
 	session.Stderr = &stderr
-	err = session.Run(command)
-	if err != nil || stderr.Len() > 0 {
+	run := command
+	if opts.sudo {
+		run = sudoCommand(command, opts.sudoPassword)
+		if opts.sudoPassword != "" { // Read by sudo -S instead of prompting on a terminal
+			session.Stdin = strings.NewReader(opts.sudoPassword + "\n")
+		}
+	}
+	var timedOut atomic.Bool
+	if opts.timeout > 0 {
+		timer := time.AfterFunc(opts.timeout, func() {
+			timedOut.Store(true)
+			session.Close()
+		})
+		defer timer.Stop()
+	}
+	err = session.Run(run)
+	if timedOut.Load() {
+		return "", fmt.Errorf("failed to execute command %s: timed out after %s", command, opts.timeout)
+	}
+	commandStderr := stderr.String()
+	if opts.sudo {
+		if sudoErr := sudoError(commandStderr, opts.sudoPassword != ""); sudoErr != nil {
+			return "", sudoErr
+		}
+		commandStderr = withoutSudoWarnings(commandStderr)
+	}
+	if err == nil && commandStderr != "" { // The command succeeded but wrote to stderr, which is treated as a failure
+		err = errors.New(strings.TrimSpace(commandStderr))
+	}
+	if err != nil {
 		return "", fmt.Errorf("failed to execute command %s: %s", command, err)
 	}
 	return stdout.String(), nil
 }
+
+// sudoCommand wraps a command so that it is run by a root shell. Without a password sudo is run non-interactively so
+// that it fails rather than waiting for a password that will never be entered
+func sudoCommand(command, password string) string {
+	if password == "" {
+		return "sudo -n -- sh -c " + shellQuote(command)
+	}
+	return "sudo -S -p '' -- sh -c " + shellQuote(command)
+}
+
+// sudoRefusals are the messages sudo prints when it refuses to run a command. Anything else sudo prints, such as
+// warnings that it is unable to resolve the host's own name, does not stop the command from running
+var sudoRefusals = []string{
+	"a password is required",
+	"no password was provided",
+	"incorrect password attempt",
+	"is not in the sudoers file",
+	"is not allowed to execute",
+	"is not allowed to run sudo",
+	"you must have a tty to run sudo",
+	"no tty present and no askpass program specified",
+	"account validation failure",
+}
+
+// sudoError turns sudo's complaints on stderr into an error explaining why sudo refused to run the command, or returns
+// nil if sudo did not refuse
+func sudoError(stderr string, hasPassword bool) error {
+	for _, line := range strings.Split(stderr, "\n") {
+		// Older versions of sudo report users that are not in the sudoers file without the sudo: prefix
+		message, _ := strings.CutPrefix(strings.TrimSpace(line), "sudo: ")
+		if !slices.ContainsFunc(sudoRefusals, func(refusal string) bool { return strings.Contains(message, refusal) }) {
+			continue
+		}
+		switch {
+		case strings.Contains(message, "a password is required") ||
+			(!hasPassword && strings.Contains(message, "no password was provided")):
+			return errors.New("sudo denied: a password is required. Set become_password for the host")
+		case strings.Contains(message, "incorrect password") || strings.Contains(message, "no password was provided"):
+			return errors.New("sudo denied: become_password is incorrect")
+		default:
+			return fmt.Errorf("sudo denied: %s", message)
+		}
+	}
+	if strings.Contains(stderr, "Sorry, try again") {
+		return errors.New("sudo denied: become_password is incorrect")
+	}
+	return nil
+}
+
+// withoutSudoWarnings removes the lines sudo printed from stderr, leaving only what the command itself printed
+func withoutSudoWarnings(stderr string) string {
+	var lines []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "sudo: ") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// shellQuote quotes a value so that the shell passes it on as a single argument
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -1,6 +1,11 @@
 package tui
 
-import "github.com/charmbracelet/bubbles/key"
+import (
+	"fmt"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/lipgloss"
+	"strings"
+)
 
 // keyMap defines keybindings. It satisfies to the help.KeyMap interface, which
 // is used to render the help menu.
@@ -13,11 +18,21 @@ type keyMap struct {
 
 // HelpView is a helper method for rendering the help menu from the keymap.
 // Note that this view is not rendered by default and you must call it
-// manually in your application, where applicable.
+// manually in your application, where applicable. Failures to reload the inventory are shown on the same line, so
+// that they are seen from every view without taking space from the charts
 func (m Model) HelpView() string {
-	return m.Help.ShortHelpView([]key.Binding{keys.Quit, keys.Previous, keys.Next, keys.ViewToggle})
+	help := m.Help.ShortHelpView([]key.Binding{keys.Quit, keys.Previous, keys.Next, keys.ViewToggle})
+	if m.reloadError == nil {
+		return help
+	}
+	// Errors for several problems in the inventory are joined by newlines
+	reason := strings.ReplaceAll(m.reloadError.Error(), "\n", "; ")
+	reloadError := fmt.Sprintf(" • failed to reload inventory, still monitoring the previous hosts: %s", reason)
+	return lipgloss.NewStyle().MaxWidth(m.width).Render(help + reloadErrorStyle.Render(reloadError))
 }
 
+var reloadErrorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+
 var keys = keyMap{
 	Quit: key.NewBinding(
 		key.WithKeys("q", "ctrl+c"),
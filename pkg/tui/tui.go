@@ -39,6 +39,8 @@ type Model struct {
 	diskBarChart            barchart.Model
 	networkingSentChart     counter.Model
 	networkingReceivedChart counter.Model
+	processIOReadChart      counter.Model
+	processIOWrittenChart   counter.Model
 
 	// Historical data
 	memLineGraph  linegraph.Model
@@ -46,31 +48,31 @@ type Model struct {
 	diskLineGraph linegraph.Model
 
 	statsChan chan *statistics.HostStat
+	hostsChan chan []inventory.Host // Receives the updated host list when the inventory changes
+	// Receives why the inventory failed to reload, or nil once it reloads again
+	reloadErrChan chan error
+	reloadError   error // Shown alongside the help until the inventory reloads successfully
 
 	inventoryNameToIndexMap map[string]int // Mapping of the name of the host to the index in which it will be displayed
 	inventoryIndexToNameMap map[int]string
+	hostGroups              map[string][]string // Mapping of the name of the host to the inventory groups it belongs to
+	hostCollectsIO          map[string]bool     // Hosts that collect the io metric, which adds a column to the live view
 	currentIndex            int
 	statsCollector          map[string][]*statistics.HostStat // Mapping of hosts to all of their last collected stats
+	connectionErrors        map[string]*statistics.HostStat   // Mapping of hosts that are not connected to their last failed attempt
 }
 
-func Initialize(ctx context.Context, inventoryInfo []inventory.Host, statsChan chan *statistics.HostStat) {
+func Initialize(ctx context.Context, inventoryInfo []inventory.Host, statsChan chan *statistics.HostStat, hostsChan chan []inventory.Host, reloadErrChan chan error) {
 	lipgloss.SetColorProfile(termenv.ANSI256)
-	p := tea.NewProgram(initialModel(ctx, inventoryInfo, statsChan))
+	p := tea.NewProgram(initialModel(ctx, inventoryInfo, statsChan, hostsChan, reloadErrChan))
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error: %v", err)
 		os.Exit(1)
 	}
 }
 
-func initialModel(ctx context.Context, inventoryInfo []inventory.Host, statsChan chan *statistics.HostStat) tea.Model {
-	var hostAliasToIndexMap = make(map[string]int)
-	var hostIndexToAliasMap = make(map[int]string)
-	for i, host := range inventoryInfo {
-		hostAliasToIndexMap[host.Alias] = i
-		hostIndexToAliasMap[i] = host.Alias
-	}
-
-	return Model{
+func initialModel(ctx context.Context, inventoryInfo []inventory.Host, statsChan chan *statistics.HostStat, hostsChan chan []inventory.Host, reloadErrChan chan error) tea.Model {
+	m := Model{
 		ctx:  ctx,
 		Help: help.New(),
 
@@ -82,22 +84,27 @@ func initialModel(ctx context.Context, inventoryInfo []inventory.Host, statsChan
 		diskBarChart:            barchart.New("disk", unit.Megabyte, 0, 0),
 		networkingSentChart:     counter.New("Net Sent", unit.Megabyte),
 		networkingReceivedChart: counter.New("Net Recv", unit.Megabyte),
+		processIOReadChart:      counter.New("IO Read", unit.Megabyte),
+		processIOWrittenChart:   counter.New("IO Write", unit.Megabyte),
 
 		// Historical data charts
 		memLineGraph:  linegraph.New("memory", unit.Megabyte, 0, 0),
 		cpuLineGraph:  linegraph.New("cpu", unit.Percentage, 0, 100),
 		diskLineGraph: linegraph.New("disk", unit.Megabyte, 0, 0),
 
-		statsChan: statsChan,
+		statsChan:     statsChan,
+		hostsChan:     hostsChan,
+		reloadErrChan: reloadErrChan,
 
-		inventoryNameToIndexMap: hostAliasToIndexMap,
-		inventoryIndexToNameMap: hostIndexToAliasMap,
-		currentIndex:            0,
-		statsCollector:          make(map[string][]*statistics.HostStat),
+		currentIndex:     0,
+		statsCollector:   make(map[string][]*statistics.HostStat),
+		connectionErrors: make(map[string]*statistics.HostStat),
 	}
+	m.setHosts(inventoryInfo)
+	return m
 }
 
 func (m Model) Init() tea.Cmd {
-	// Start listening to the statsChan
-	return listenForStats(m.ctx, m.statsChan)
+	// Start listening to the statsChan, hostsChan and reloadErrChan
+	return tea.Batch(listenForStats(m.ctx, m.statsChan), listenForHosts(m.ctx, m.hostsChan), listenForReloadErrors(m.ctx, m.reloadErrChan))
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kreulenk/ez-monitor/pkg/inventory"
 	"github.com/kreulenk/ez-monitor/pkg/renderutils"
 	"github.com/kreulenk/ez-monitor/pkg/statistics"
 	"os"
@@ -12,6 +13,12 @@ import (
 // statsMsg wraps the statistics.HostStat to implement tea.Msg.
 type statsMsg *statistics.HostStat
 
+// hostsMsg wraps the updated list of monitored hosts to implement tea.Msg.
+type hostsMsg []inventory.Host
+
+// reloadErrorMsg wraps why the inventory failed to reload, or nil once it has reloaded, to implement tea.Msg.
+type reloadErrorMsg struct{ err error }
+
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
@@ -21,11 +28,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, keys.Next):
 			if m.currentIndex < len(m.inventoryNameToIndexMap)-1 {
 				m.currentIndex++
+				m.resizeLiveCharts()
 				m.updateActiveCharts()
 			}
 		case key.Matches(msg, keys.Previous):
 			if m.currentIndex > 0 {
 				m.currentIndex--
+				m.resizeLiveCharts()
 				m.updateActiveCharts()
 			}
 		case key.Matches(msg, keys.ViewToggle):
@@ -36,6 +45,15 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 	case statsMsg:
+		if _, ok := m.inventoryNameToIndexMap[msg.HostAlias]; !ok { // The host was removed from the inventory
+			return m, listenForStats(m.ctx, m.statsChan)
+		}
+		if msg.ConnectionError != nil { // Shown by View until the host is reconnected, keeping the stats collected so far
+			m.connectionErrors[msg.HostAlias] = msg
+			return m, listenForStats(m.ctx, m.statsChan)
+		}
+		delete(m.connectionErrors, msg.HostAlias)
+
 		// Append the statistic to the statsCollector for each host
 		if _, ok := m.statsCollector[msg.HostAlias]; ok {
 			m.statsCollector[msg.HostAlias] = append(m.statsCollector[msg.HostAlias], msg)
@@ -49,6 +67,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		return m, listenForStats(m.ctx, m.statsChan)
+	case hostsMsg:
+		m.setHosts(msg)
+		return m, listenForHosts(m.ctx, m.hostsChan)
+	case reloadErrorMsg:
+		m.reloadError = msg.err
+		return m, listenForReloadErrors(m.ctx, m.reloadErrChan)
 	case tea.WindowSizeMsg:
 		m.height = msg.Height
 		m.width = msg.Width
@@ -58,20 +82,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		// TODO we should probably use an interface to set these values at this point..
-		m.memBarChart.SetWidth(m.width/4 - 2)
+		m.resizeLiveCharts()
 		m.memBarChart.SetHeight(m.height - 2)
-
-		m.cpuBarChart.SetWidth(m.width/4 - 2)
 		m.cpuBarChart.SetHeight(m.height - 2)
-
-		m.diskBarChart.SetWidth(m.width/4 - 2)
 		m.diskBarChart.SetHeight(m.height - 2)
-
-		m.networkingSentChart.SetWidth(m.width/4 - 2)
 		m.networkingSentChart.SetHeight(m.height/2 - 2)
-
-		m.networkingReceivedChart.SetWidth(m.width/4 - 2)
 		m.networkingReceivedChart.SetHeight(m.height/2 - 2)
+		m.processIOReadChart.SetHeight(m.height/2 - 2)
+		m.processIOWrittenChart.SetHeight(m.height/2 - 2)
 
 		m.memLineGraph.SetWidth(m.width - 2)
 		m.memLineGraph.SetHeight(m.height/3 - 3)
@@ -100,6 +118,84 @@ func listenForStats(ctx context.Context, statsChan chan *statistics.HostStat) te
 	}
 }
 
+// listenForHosts listens for updates to the monitored hosts and sends them as tea.Msg.
+func listenForHosts(ctx context.Context, hostsChan chan []inventory.Host) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case hosts := <-hostsChan:
+			return hostsMsg(hosts)
+		case <-ctx.Done():
+			return tea.Quit()
+		}
+	}
+}
+
+// listenForReloadErrors listens for failed inventory reloads and sends them as tea.Msg.
+func listenForReloadErrors(ctx context.Context, reloadErrChan chan error) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case err := <-reloadErrChan:
+			return reloadErrorMsg{err: err}
+		case <-ctx.Done():
+			return tea.Quit()
+		}
+	}
+}
+
+// setHosts replaces the hosts that can be viewed, keeping the collected stats of every host that is still present and
+// staying on the current host if it was not removed
+func (m *Model) setHosts(hosts []inventory.Host) {
+	currentHost := m.inventoryIndexToNameMap[m.currentIndex]
+
+	m.inventoryNameToIndexMap = make(map[string]int)
+	m.inventoryIndexToNameMap = make(map[int]string)
+	m.hostGroups = make(map[string][]string)
+	m.hostCollectsIO = make(map[string]bool)
+	for i, host := range hosts {
+		m.inventoryNameToIndexMap[host.Alias] = i
+		m.inventoryIndexToNameMap[i] = host.Alias
+		m.hostGroups[host.Alias] = host.Groups
+		m.hostCollectsIO[host.Alias] = host.CollectsMetric(inventory.MetricProcessIO)
+	}
+	for alias := range m.statsCollector {
+		if _, ok := m.inventoryNameToIndexMap[alias]; !ok {
+			delete(m.statsCollector, alias)
+		}
+	}
+	for alias := range m.connectionErrors {
+		if _, ok := m.inventoryNameToIndexMap[alias]; !ok {
+			delete(m.connectionErrors, alias)
+		}
+	}
+
+	if i, ok := m.inventoryNameToIndexMap[currentHost]; ok {
+		m.currentIndex = i
+	} else {
+		m.currentIndex = renderutils.Max(0, renderutils.Min(m.currentIndex, len(hosts)-1))
+	}
+	m.resizeLiveCharts()
+	m.updateActiveCharts()
+}
+
+// resizeLiveCharts splits the width of the window between the columns of the live view, which only has a column for
+// process IO when the current host collects the io metric
+func (m *Model) resizeLiveCharts() {
+	if m.width == 0 { // The window size is not known until the first tea.WindowSizeMsg
+		return
+	}
+	columns := 4
+	if m.hostCollectsIO[m.inventoryIndexToNameMap[m.currentIndex]] {
+		columns = 5
+	}
+	m.memBarChart.SetWidth(m.width/columns - 2)
+	m.cpuBarChart.SetWidth(m.width/columns - 2)
+	m.diskBarChart.SetWidth(m.width/columns - 2)
+	m.networkingSentChart.SetWidth(m.width/columns - 2)
+	m.networkingReceivedChart.SetWidth(m.width/columns - 2)
+	m.processIOReadChart.SetWidth(m.width/columns - 2)
+	m.processIOWrittenChart.SetWidth(m.width/columns - 2)
+}
+
 func (m *Model) updateActiveCharts() {
 	lastStat := m.getLastDataPoint()
 	if lastStat == nil {
@@ -136,6 +232,14 @@ func (m *Model) updateLiveChildModelStats(stats *statistics.HostStat) {
 		m.networkingSentChart.SetDataCollectionErr(stats.NetworkingError)
 		m.networkingReceivedChart.SetDataCollectionErr(stats.NetworkingError)
 	}
+
+	if stats.ProcessIOError == nil {
+		m.processIOReadChart.SetCurrentValue(stats.ProcessIOMBRead)
+		m.processIOWrittenChart.SetCurrentValue(stats.ProcessIOMBWritten)
+	} else {
+		m.processIOReadChart.SetDataCollectionErr(stats.ProcessIOError)
+		m.processIOWrittenChart.SetDataCollectionErr(stats.ProcessIOError)
+	}
 }
 
 func (m *Model) updateHistoricalChildModelStats(stats *statistics.HostStat) {
@@ -1,9 +1,22 @@
 package tui
 
-import "github.com/charmbracelet/lipgloss"
+import (
+	"fmt"
+	"github.com/charmbracelet/lipgloss"
+	"strings"
+)
 
 func (m Model) View() string {
 	currentHost := m.inventoryIndexToNameMap[m.currentIndex]
+	failed, reconnecting := m.connectionErrors[currentHost]
+	if _, ok := m.statsCollector[currentHost]; !ok && reconnecting { // The host has never been connected to
+		return lipgloss.JoinVertical(lipgloss.Left,
+			lipgloss.JoinVertical(lipgloss.Center, currentHost, fmt.Sprintf("Unable to connect: %s", failed.ConnectionError),
+				fmt.Sprintf("Reconnecting (attempt %d)...", failed.ReconnectAttempt),
+				m.HelpView(),
+			),
+		)
+	}
 	if _, ok := m.statsCollector[currentHost]; ok {
 		if m.activeView == LiveData {
 			return m.renderLiveDataView(currentHost)
@@ -21,10 +34,14 @@ func (m Model) View() string {
 
 func (m Model) renderLiveDataView(currentHost string) string {
 	networkingCounters := joinVerticalStackedElementsWithBuffers(m.networkingSentChart.View(), m.networkingReceivedChart.View(), m.height)
+	columns := []string{m.memBarChart.View(), m.cpuBarChart.View(), m.diskBarChart.View(), networkingCounters}
+	if m.hostCollectsIO[currentHost] {
+		columns = append(columns, joinVerticalStackedElementsWithBuffers(m.processIOReadChart.View(), m.processIOWrittenChart.View(), m.height))
+	}
 
 	return lipgloss.JoinVertical(lipgloss.Top,
 		lipgloss.JoinVertical(lipgloss.Top, m.renderCurrentHostTopBar(currentHost),
-			lipgloss.JoinHorizontal(lipgloss.Left, m.memBarChart.View(), m.cpuBarChart.View(), m.diskBarChart.View(), networkingCounters),
+			lipgloss.JoinHorizontal(lipgloss.Left, columns...),
 		),
 		m.HelpView(),
 	)
@@ -38,6 +55,13 @@ func (m Model) renderHistoricalDataView(currentHost string) string {
 
 // renderCurrentHostTopBar will properly place the currentHost value in the middle of the top bar
 func (m Model) renderCurrentHostTopBar(currentHost string) string {
+	alias := currentHost
+	if groups := m.hostGroups[alias]; len(groups) > 0 {
+		currentHost = fmt.Sprintf("%s (%s)", currentHost, strings.Join(groups, ", "))
+	}
+	if failed, ok := m.connectionErrors[alias]; ok { // The stats shown are from before the connection was lost
+		currentHost = fmt.Sprintf("%s - reconnecting (attempt %d): %s", currentHost, failed.ReconnectAttempt, failed.ConnectionError)
+	}
 	return lipgloss.NewStyle().PaddingLeft(m.width/2 - len(currentHost)/2).Render(currentHost)
 }
 